@@ -0,0 +1,52 @@
+package key_helper
+
+import (
+	"context"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	authCmd.AddCommand(authStatusCmd)
+}
+
+var authStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "log in with the selected profile and show the resulting token",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		profile, vaultClient, err := getClient(ctx)
+		if err != nil {
+			return err
+		}
+		resp, err := vaultClient.Auth.TokenReadLookupSelf(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to look up token, %v", err)
+		}
+
+		method := keyvault.AuthMethodToken
+		if profile.Auth != nil && profile.Auth.Method != "" {
+			method = profile.Auth.Method
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Profile:\t%s\n", profile.Name)
+		fmt.Fprintf(w, "Address:\t%s\n", profile.Address)
+		if profile.Namespace != "" {
+			fmt.Fprintf(w, "Namespace:\t%s\n", profile.Namespace)
+		}
+		fmt.Fprintf(w, "Auth method:\t%s\n", method)
+		fmt.Fprintf(w, "Display name:\t%v\n", resp.Data["display_name"])
+		fmt.Fprintf(w, "Policies:\t%v\n", resp.Data["policies"])
+		if ttl, ok := resp.Data["ttl"].(float64); ok && ttl > 0 {
+			fmt.Fprintf(w, "TTL:\t%v\n", time.Duration(ttl)*time.Second)
+		} else {
+			fmt.Fprintf(w, "TTL:\tnever expires\n")
+		}
+		return w.Flush()
+	},
+}
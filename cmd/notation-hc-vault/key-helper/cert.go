@@ -0,0 +1,162 @@
+package key_helper
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	certCmd.AddCommand(showCertCmd, importCertCmd, attachCertCmd, expiringCertCmd)
+	showCertCmd.Flags().Bool("pem", false, "print the chain as PEM")
+	for _, cmd := range []*cobra.Command{importCertCmd, attachCertCmd} {
+		cmd.Flags().String("cert_path", "", "path to the certificate chain file, leaf first")
+		cmd.Flags().Int("key_version", 0, "transit key version the chain certifies (default the version matching the leaf certificate)")
+		cmd.Flags().Bool("force", false, "store the chain even if it fails validation")
+		cmd.MarkFlagRequired("cert_path")
+	}
+	expiringCertCmd.Flags().String("within", "30d", "report the chains expiring within this duration, e.g. 30d or 72h")
+	expiringCertCmd.Flags().StringP("output", "o", "table", "output format, table or json")
+	expiringCertCmd.Flags().Bool("all_profiles", false, "report the keys of every profile in the config file")
+}
+
+var showCertCmd = &cobra.Command{
+	Use:   "show <key>",
+	Short: "show the certificate chain stored for a key",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		asPEM, _ := cmd.Flags().GetBool("pem")
+		profile, vaultClient, err := getClient(ctx)
+		if err != nil {
+			return err
+		}
+		chain, err := keyvault.ReadCertificateChain(ctx, vaultClient, profile, args[0])
+		if err != nil {
+			return err
+		}
+		if asPEM {
+			for _, cert := range chain.Certificates {
+				pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+			}
+			return nil
+		}
+		location := profile.KVLocation(args[0])
+		if chain.Version > 0 {
+			location = fmt.Sprintf("%s (version %d)", location, chain.Version)
+		}
+		fmt.Println(location)
+		if chain.KeyVersion != 0 {
+			fmt.Printf("Paired with key version %d\n", chain.KeyVersion)
+		}
+		for i, cert := range chain.Certificates {
+			fmt.Printf("[%d] Subject: %s\n    Issuer:  %s\n    Valid:   %s to %s\n", i, cert.Subject, cert.Issuer,
+				cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339))
+		}
+		return nil
+	},
+}
+
+// importCertCmd is the former name of attachCertCmd, which stored the chain
+// without pairing it with a key version.
+var importCertCmd = &cobra.Command{
+	Use:        "import <key>",
+	Short:      "store the certificate chain of a key version, same as cert attach",
+	Deprecated: "use cert attach, which it now runs",
+	Args:       cobra.ExactArgs(1),
+	RunE:       runAttachCert,
+}
+
+var attachCertCmd = &cobra.Command{
+	Use:   "attach <key>",
+	Short: "store the certificate chain of a key version and sign with that version",
+	Long: `store the certificate chain of a key version and sign with that version
+
+The leaf certificate must certify a version of the transit key. The chain is
+stored paired with that version, which signing uses from then on. The chain
+must meet the Notary Project certificate requirements unless --force is set.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAttachCert,
+}
+
+func runAttachCert(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	certPath, _ := cmd.Flags().GetString("cert_path")
+	keyVersion, _ := cmd.Flags().GetInt("key_version")
+	force, _ := cmd.Flags().GetBool("force")
+	certs, err := readCertificateChain(certPath)
+	if err != nil {
+		return err
+	}
+	if err := checkSigningCertificates(nil, certs, force); err != nil {
+		return err
+	}
+	profile, vaultClient, err := getClient(ctx)
+	if err != nil {
+		return err
+	}
+	version, err := keyvault.AttachCertificateChain(ctx, vaultClient, profile, args[0], certs, keyVersion)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Successfully stored certificate chain at %s, signing now uses version %d\n", profile.KVLocation(args[0]), version)
+	return nil
+}
+
+var expiringCertCmd = &cobra.Command{
+	Use:   "expiring",
+	Short: "report the certificate chains expiring soon",
+	Long: `report the certificate chains expiring soon
+
+Lists, soonest first, the transit keys whose certificate chain has a
+certificate, leaf or intermediate, that expires within the --within duration
+or has already expired, e.g. for the on-call rotation:
+
+  notation-hc-vault cert expiring --within 30d --all_profiles`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		within, _ := cmd.Flags().GetString("within")
+		output, _ := cmd.Flags().GetString("output")
+		allProfiles, _ := cmd.Flags().GetBool("all_profiles")
+		if output != "table" && output != "json" {
+			return fmt.Errorf("unsupported output format %q, must be table or json", output)
+		}
+		window, err := keyvault.ParseDuration(within)
+		if err != nil {
+			return err
+		}
+
+		infos, err := inventoryAll(ctx, allProfiles)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		expiring := keyvault.ExpiringKeys(infos, now.Add(window))
+
+		if output == "json" {
+			if expiring == nil {
+				expiring = []*keyvault.KeyInfo{}
+			}
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "    ")
+			return encoder.Encode(expiring)
+		}
+		if len(expiring) == 0 {
+			fmt.Printf("No certificate chain expires within %s\n", within)
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PROFILE\tKEY\tSUBJECT\tEXPIRES\tREMAINING")
+		for _, info := range expiring {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", info.Profile, info.Key, info.Subject, info.ChainNotAfter.Format("2006-01-02"), keyvault.FormatRemaining(info.ChainNotAfter.Sub(now)))
+		}
+		return w.Flush()
+	},
+}
@@ -0,0 +1,314 @@
+package key_helper
+
+import (
+	"context"
+	gocrypto "crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/crypto"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/OliverShang/notation-hc-vault/internal/trust"
+	"github.com/notaryproject/notation-go/dir"
+	"github.com/notaryproject/notation-go/verifier/trustpolicy"
+	"github.com/spf13/cobra"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	devCAKeyFile  = "ca.key"
+	devCACertFile = "ca.crt"
+	devCASubject  = "C=US,ST=WA,O=Notation Dev,CN=Notation Dev Root CA"
+	devCAValidity = 5 * 365 * 24 * time.Hour
+)
+
+func init() {
+	devCmd.AddCommand(devInitCmd)
+	devInitCmd.Flags().String("key_name", "notation-dev", "name of the transit key, notation signing key and trust store")
+	devInitCmd.Flags().String("type", "rsa-3072", "transit key type of a generated key, one of "+strings.Join(keyvault.TransitKeyTypes, ", "))
+	devInitCmd.Flags().String("key_path", "", "private key file or PKCS #12 bundle to import instead of generating a key in transit")
+	devInitCmd.Flags().String("passphrase_file", "", "path to a file holding the passphrase of the private key")
+	devInitCmd.Flags().String("subject", "", "leaf certificate subject (default \"C=US,ST=WA,O=Notation Dev,CN=<key name>\")")
+	devInitCmd.Flags().Duration("validity", 365*24*time.Hour, "validity of the leaf certificate")
+	devInitCmd.Flags().String("out_dir", "notation-dev", "directory of the local test CA, created as needed and reused by later runs")
+	devInitCmd.Flags().StringSlice("registry_scopes", []string{"*"}, "registry scopes of the trust policy")
+	devInitCmd.Flags().Bool("default", false, "make the key notation's default signing key")
+	devInitCmd.Flags().Bool("force", false, "run against a Vault server that is not on the loopback interface")
+}
+
+var devInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "bootstrap signing and verification against a dev Vault server",
+	Long: `bootstrap signing and verification against a dev Vault server
+
+Replaces the manual OpenSSL workflow with a single command, against the Vault
+server of the selected profile, e.g. "vault server -dev" with VAULT_ADDR and
+VAULT_TOKEN set:
+
+  1. enables the transit and KV v2 secrets engines of the profile if missing
+  2. creates a local test CA in --out_dir, or reuses the one there
+  3. generates the signing key in transit, or imports --key_path
+  4. issues a code signing certificate for the key from the test CA and
+     stores the chain in KV, paired with the key version
+  5. registers the key with notation
+  6. writes the test CA to the notation trust store named after the key and
+     adds a trust policy for it to notation's trustpolicy.json
+
+Re-running the command reuses the transit key and the test CA and issues a
+new certificate. The test CA key is kept unencrypted on disk; never trust it
+outside development. The command refuses Vault servers that are not on the
+loopback interface unless --force is set.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		keyName, _ := cmd.Flags().GetString("key_name")
+		keyType, _ := cmd.Flags().GetString("type")
+		keyPath, _ := cmd.Flags().GetString("key_path")
+		passphraseFile, _ := cmd.Flags().GetString("passphrase_file")
+		subjectFlag, _ := cmd.Flags().GetString("subject")
+		validity, _ := cmd.Flags().GetDuration("validity")
+		outDir, _ := cmd.Flags().GetString("out_dir")
+		registryScopes, _ := cmd.Flags().GetStringSlice("registry_scopes")
+		markDefault, _ := cmd.Flags().GetBool("default")
+		force, _ := cmd.Flags().GetBool("force")
+		if validity <= 0 {
+			return errors.New("--validity must be positive")
+		}
+		if subjectFlag == "" {
+			subjectFlag = "C=US,ST=WA,O=Notation Dev,CN=" + keyName
+		}
+		subject, err := crypto.ParseSubject(subjectFlag)
+		if err != nil {
+			return err
+		}
+		if len(subject.Country) == 0 || len(subject.Province) == 0 || len(subject.Organization) == 0 {
+			return errors.New("--subject must have C, ST and O, as notation requires them in trusted identities")
+		}
+		storeDir, err := trust.StoreDir(notationConfigDir(), trust.StoreTypeCA, keyName)
+		if err != nil {
+			return err
+		}
+
+		profile, err := getProfile()
+		if err != nil {
+			return err
+		}
+		if !isLoopbackAddress(profile.Address) && !force {
+			return fmt.Errorf("%s is not a local dev server, dev init enables secrets engines and creates keys there; set --force to proceed anyway", profile.Address)
+		}
+		vaultClient, err := keyvault.NewClient(ctx, profile)
+		if err != nil {
+			return err
+		}
+		enabled, err := keyvault.EnableProfileMounts(ctx, vaultClient, profile)
+		if err != nil {
+			return err
+		}
+		for _, mount := range enabled {
+			fmt.Printf("Enabled secrets engine %s\n", mount)
+		}
+
+		ca, caKey, err := loadOrCreateDevCA(outDir)
+		if err != nil {
+			return err
+		}
+
+		_, err = keyvault.ReadTransitKey(ctx, vaultClient, profile, keyName)
+		switch {
+		case err == nil && keyPath != "":
+			return fmt.Errorf("transit key %s/keys/%s already exists, choose another --key_name to import %s", profile.TransitMount, keyName, keyPath)
+		case err == nil:
+			fmt.Printf("Using existing key %s/keys/%s\n", profile.TransitMount, keyName)
+		case !errors.Is(err, keyvault.ErrTransitKeyNotFound):
+			return err
+		case keyPath != "":
+			privateKey, _, err := crypto.ReadPrivateKeyFile(keyPath, passphraseReader(passphraseFile, keyPath))
+			if err != nil {
+				return fmt.Errorf("failed to read private key, %v", err)
+			}
+			defer crypto.ZeroizePrivateKey(privateKey)
+			importedType, err := keyvault.TransitKeyType(privateKey)
+			if err != nil {
+				return err
+			}
+			if _, err := importPrivateKey(ctx, vaultClient, profile, keyName, privateKey, importedType, false); err != nil {
+				return err
+			}
+			fmt.Printf("Imported %s key %s/keys/%s\n", importedType, profile.TransitMount, keyName)
+		default:
+			if err := keyvault.CreateTransitKey(ctx, vaultClient, profile, keyName, keyType, 0); err != nil {
+				return err
+			}
+			fmt.Printf("Generated %s key %s/keys/%s\n", keyType, profile.TransitMount, keyName)
+		}
+
+		signer, err := keyvault.NewTransitSigner(ctx, vaultClient, profile, keyName, 0)
+		if err != nil {
+			return err
+		}
+		leaf, err := crypto.IssueCodeSigningCertificate(signer.Public(), subject, validity, ca, caKey)
+		if err != nil {
+			return fmt.Errorf("failed to issue certificate, %v", err)
+		}
+		certs := []*x509.Certificate{leaf, ca}
+		if err := crypto.ValidateSigningCertificates(signer.Public(), certs, time.Now()); err != nil {
+			return fmt.Errorf("the issued certificate chain is not usable for signing, %v", err)
+		}
+		version, err := keyvault.AttachCertificateChain(ctx, vaultClient, profile, keyName, certs, signer.Version())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Stored certificate %q for version %d at %s\n", leaf.Subject, version, profile.KVLocation(keyName))
+
+		if err := registerNotationKey(ctx, keyName, keyName, defaultPluginConfig(), markDefault); err != nil {
+			return err
+		}
+
+		written, err := trust.WriteCertificates(storeDir, []*x509.Certificate{ca})
+		if err != nil {
+			return fmt.Errorf("failed to write trust store, %v", err)
+		}
+		for _, path := range written {
+			fmt.Printf("Wrote %s\n", path)
+		}
+		policy, err := trust.Policy(keyName, keyName, registryScopes, leaf)
+		if err != nil {
+			return fmt.Errorf("invalid trust policy, %v", err)
+		}
+		if err := addDevTrustPolicy(policy, outDir); err != nil {
+			return err
+		}
+
+		fmt.Println("\nSign and verify an artifact with:")
+		fmt.Printf("  notation sign --key %s <registry>/<repository>@<digest>\n", keyName)
+		fmt.Println("  notation verify <registry>/<repository>@<digest>")
+		return nil
+	},
+}
+
+// isLoopbackAddress reports whether the Vault address is on the loopback
+// interface, as the address of "vault server -dev" is.
+func isLoopbackAddress(address string) bool {
+	u, err := url.Parse(address)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// loadOrCreateDevCA reads the test CA of outDir, creating it first if it does
+// not exist.
+func loadOrCreateDevCA(outDir string) (*x509.Certificate, gocrypto.Signer, error) {
+	keyPath := filepath.Join(outDir, devCAKeyFile)
+	certPath := filepath.Join(outDir, devCACertFile)
+	if _, err := os.Stat(certPath); err == nil {
+		certs, err := readCertificateChain(certPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		privateKey, _, err := crypto.ReadPrivateKeyFile(keyPath, passphraseReader("", keyPath))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read test CA key, %v", err)
+		}
+		signer, ok := privateKey.(gocrypto.Signer)
+		if !ok || len(certs) != 1 || !certs[0].IsCA {
+			return nil, nil, fmt.Errorf("%s does not hold a test CA, remove it to create a new one", outDir)
+		}
+		if time.Now().After(certs[0].NotAfter) {
+			return nil, nil, fmt.Errorf("test CA %s expired on %s, remove it to create a new one", certPath, certs[0].NotAfter.Format(time.RFC3339))
+		}
+		fmt.Printf("Using test CA %s\n", certPath)
+		return certs[0], signer, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	subject, err := crypto.ParseSubject(devCASubject)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, caKey, err := crypto.CreateCertificateAuthority(subject, devCAValidity)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create test CA, %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.MkdirAll(outDir, 0700); err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0644); err != nil {
+		return nil, nil, err
+	}
+	fmt.Printf("Created test CA %s\n", certPath)
+	return ca, caKey, nil
+}
+
+// addDevTrustPolicy merges the policy into notation's trustpolicy.json,
+// backing it up first. If notation would reject the merged document, e.g.
+// because another policy has the wildcard registry scope, the policy is
+// written to outDir instead, to be merged by hand.
+func addDevTrustPolicy(policy *trustpolicy.Document, outDir string) error {
+	path, err := dir.ConfigFS().SysPath(dir.PathTrustPolicy)
+	if err != nil {
+		return err
+	}
+	doc := policy
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		existing := &trustpolicy.Document{}
+		if err := json.Unmarshal(data, existing); err != nil {
+			return fmt.Errorf("failed to parse %s, %v", path, err)
+		}
+		if err := trust.MergePolicy(existing, policy); err != nil {
+			fallback := filepath.Join(outDir, dir.PathTrustPolicy)
+			if err := writeTrustPolicy(fallback, policy); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Warning: the trust policy cannot be added to %s, %v\nmerge %s into it by hand\n", path, err, fallback)
+			return nil
+		}
+		backup, err := backupNotationFile(dir.PathTrustPolicy)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Backed up %s to %s\n", dir.PathTrustPolicy, backup)
+		doc = existing
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	if err := writeTrustPolicy(path, doc); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote trust policy %q to %s\n", policy.TrustPolicies[0].Name, path)
+	return nil
+}
+
+func writeTrustPolicy(path string, doc *trustpolicy.Document) error {
+	data, err := json.MarshalIndent(doc, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
@@ -0,0 +1,80 @@
+package key_helper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+)
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().StringP("output", "o", "text", "output format, text or json")
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor <key id>",
+	Short: "diagnose signing with a key",
+	Long: `diagnose signing with a key
+
+Runs the checks of signing with the key ID, a key name or alias, resolved the
+same way the plugin does, and reports each one as pass, warn or fail with a
+hint to fix it:
+
+  profile              the Vault profile is found and valid
+  connectivity         Vault is reachable at the profile address
+  seal status          Vault is unsealed
+  authentication       the profile logs in to Vault
+  token                the token is valid and does not expire soon
+  key alias            the alias table of the profile resolves the key ID
+  capabilities         the token may sign with the transit key and read the
+                       certificate chain (sys/capabilities-self)
+  transit key          the transit key exists and is of a signing type
+  certificate chain    the chain parses, is ordered leaf first, meets the
+                       Notary Project requirements and is valid now
+  key and certificate  the leaf certifies the key version signing uses
+
+Checks depending on a failed one are skipped. The command exits with a
+non-zero status if any check fails.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		output, _ := cmd.Flags().GetString("output")
+		if output != "text" && output != "json" {
+			return fmt.Errorf("unsupported output format %q, must be text or json", output)
+		}
+		config, err := keyvault.ResolveConfig(configPath)
+		if err != nil {
+			return err
+		}
+		checks := keyvault.Diagnose(ctx, config, profileName, args[0])
+
+		failed := 0
+		for _, check := range checks {
+			if check.Status == keyvault.CheckFail {
+				failed++
+			}
+		}
+		if output == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "    ")
+			if err := encoder.Encode(checks); err != nil {
+				return err
+			}
+		} else {
+			for _, check := range checks {
+				fmt.Printf("[%s] %-19s %s\n", strings.ToUpper(string(check.Status)), check.Name, check.Detail)
+				if check.Hint != "" && (check.Status == keyvault.CheckFail || check.Status == keyvault.CheckWarn) {
+					fmt.Printf("       %-19s hint: %s\n", "", check.Hint)
+				}
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+		}
+		return nil
+	},
+}
@@ -0,0 +1,140 @@
+package key_helper
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/crypto"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+func init() {
+	keyCmd.AddCommand(exportKeyCmd)
+	exportKeyCmd.Flags().Int("key_version", 0, "transit key version to export (default the version the stored chain is paired with, or the latest)")
+	exportKeyCmd.Flags().StringSlice("formats", []string{"pem", "der", "p7b", "jwk"}, "formats to write, any of pem, der, p7b and jwk")
+	exportKeyCmd.Flags().String("out_dir", ".", "directory to write the files to")
+}
+
+var exportKeyCmd = &cobra.Command{
+	Use:   "export <key>",
+	Short: "write the public key and certificate chain of a key for distribution",
+	Long: `write the public key and certificate chain of a key for distribution
+
+Reads the public key of a transit key version and the certificate chain stored
+for the key, checks that the leaf certificate is the one of that key version,
+and writes them to --out_dir in the selected formats:
+
+  pem  <key>.pub.pem, the public key, and <key>.chain.pem, the chain
+  der  <key>.pub.der, the public key, and <key>.crt.der, the leaf certificate
+  p7b  <key>.p7b, the chain as a PKCS #7 certificate bundle
+  jwk  <key>.jwk.json, the public key as a JSON Web Key with the chain in x5c`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		keyName := args[0]
+		keyVersion, _ := cmd.Flags().GetInt("key_version")
+		formats, _ := cmd.Flags().GetStringSlice("formats")
+		outDir, _ := cmd.Flags().GetString("out_dir")
+		selected := make(map[string]bool)
+		for _, format := range formats {
+			switch format {
+			case "pem", "der", "p7b", "jwk":
+				selected[format] = true
+			default:
+				return fmt.Errorf("unsupported format %q, must be one of pem, der, p7b and jwk", format)
+			}
+		}
+		if len(selected) == 0 {
+			return errors.New("no formats selected")
+		}
+
+		profile, vaultClient, err := getClient(ctx)
+		if err != nil {
+			return err
+		}
+		key, err := keyvault.ReadTransitKey(ctx, vaultClient, profile, keyName)
+		if err != nil {
+			return err
+		}
+		chain, err := keyvault.ReadCertificateChain(ctx, vaultClient, profile, keyName)
+		if err != nil {
+			return err
+		}
+		if keyVersion == 0 {
+			keyVersion = chain.KeyVersion
+		}
+		if keyVersion == 0 {
+			keyVersion = key.LatestVersion
+		}
+		pub, err := key.PublicKey(keyVersion)
+		if err != nil {
+			return err
+		}
+		leaf := chain.Certificates[0]
+		if certified := keyvault.CertifiedVersion(key, leaf); certified != keyVersion {
+			if certified == 0 {
+				return fmt.Errorf("the leaf certificate %q stored at %s does not certify any version of transit key %s/keys/%s", leaf.Subject, profile.KVLocation(keyName), profile.TransitMount, keyName)
+			}
+			return fmt.Errorf("the leaf certificate %q certifies version %d of transit key %s/keys/%s, not version %d", leaf.Subject, certified, profile.TransitMount, keyName, keyVersion)
+		}
+
+		pubDER, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return fmt.Errorf("failed to encode public key, %v", err)
+		}
+		type exportFile struct {
+			suffix string
+			data   []byte
+		}
+		var files []exportFile
+		if selected["pem"] {
+			var chainPEM []byte
+			for _, cert := range chain.Certificates {
+				chainPEM = append(chainPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+			}
+			files = append(files,
+				exportFile{".pub.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})},
+				exportFile{".chain.pem", chainPEM})
+		}
+		if selected["der"] {
+			files = append(files, exportFile{".pub.der", pubDER}, exportFile{".crt.der", leaf.Raw})
+		}
+		if selected["p7b"] {
+			p7b, err := crypto.EncodePKCS7(chain.Certificates)
+			if err != nil {
+				return fmt.Errorf("failed to encode PKCS #7 bundle, %v", err)
+			}
+			files = append(files, exportFile{".p7b", p7b})
+		}
+		if selected["jwk"] {
+			jwk, err := crypto.NewJSONWebKey(pub, chain.Certificates)
+			if err != nil {
+				return fmt.Errorf("failed to encode JWK, %v", err)
+			}
+			data, err := json.MarshalIndent(jwk, "", "    ")
+			if err != nil {
+				return err
+			}
+			files = append(files, exportFile{".jwk.json", append(data, '\n')})
+		}
+
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			return err
+		}
+		for _, file := range files {
+			path := filepath.Join(outDir, keyName+file.suffix)
+			if err := os.WriteFile(path, file.data, 0644); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote %s\n", path)
+		}
+		fmt.Printf("Exported version %d of %s/keys/%s, certified by %q\n", keyVersion, profile.TransitMount, keyName, leaf.Subject)
+		return nil
+	},
+}
@@ -0,0 +1,104 @@
+package key_helper
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/crypto"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"time"
+)
+
+func init() {
+	keyCmd.AddCommand(generateCmd)
+	generateCmd.Flags().String("key_name", "", "name of the key")
+	generateCmd.Flags().String("type", "rsa-3072", "transit key type, one of "+strings.Join(keyvault.TransitKeyTypes, ", "))
+	generateCmd.Flags().Duration("auto_rotate_period", 0, "period after which transit rotates the key, 0 to disable (minimum 1h)")
+	generateCmd.Flags().String("subject", "", "certificate subject, e.g. \"CN=release,O=Example,C=US\"")
+	generateCmd.Flags().String("csr_out", "", "path to write a PEM encoded CSR for the key")
+	generateCmd.Flags().Bool("self_signed", false, "store a self-signed development certificate in KV")
+	generateCmd.Flags().Duration("validity", 365*24*time.Hour, "validity of the self-signed certificate")
+	generateCmd.MarkFlagRequired("key_name")
+	generateCmd.MarkFlagRequired("subject")
+	generateCmd.MarkFlagsMutuallyExclusive("csr_out", "self_signed")
+}
+
+var generateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "generate a non-exportable signing key in HashiCorp Vault",
+	Long: `generate a non-exportable signing key in HashiCorp Vault
+
+The key is created by the Vault Transit secrets engine and never leaves Vault.
+Either a CSR is written for a CA to issue the signing certificate, or a
+self-signed development certificate is stored in the Vault KV secrets engine.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		keyName, _ := cmd.Flags().GetString("key_name")
+		keyType, _ := cmd.Flags().GetString("type")
+		autoRotatePeriod, _ := cmd.Flags().GetDuration("auto_rotate_period")
+		subjectFlag, _ := cmd.Flags().GetString("subject")
+		csrOut, _ := cmd.Flags().GetString("csr_out")
+		selfSigned, _ := cmd.Flags().GetBool("self_signed")
+		validity, _ := cmd.Flags().GetDuration("validity")
+		if csrOut == "" && !selfSigned {
+			return errors.New("one of --csr_out or --self_signed is required")
+		}
+		if autoRotatePeriod != 0 && autoRotatePeriod < time.Hour {
+			return errors.New("--auto_rotate_period must be at least 1h")
+		}
+		if autoRotatePeriod != 0 && !selfSigned {
+			// until the issued chain is attached, nothing pairs it with the
+			// first key version, and signing would follow the rotations
+			return errors.New("--auto_rotate_period requires --self_signed, with --csr_out enable the rotation once the issued chain is attached with cert attach")
+		}
+		if validity <= 0 {
+			return errors.New("--validity must be positive")
+		}
+		subject, err := crypto.ParseSubject(subjectFlag)
+		if err != nil {
+			return err
+		}
+
+		profile, vaultClient, err := getClient(ctx)
+		if err != nil {
+			return err
+		}
+		if err := keyvault.CreateTransitKey(ctx, vaultClient, profile, keyName, keyType, autoRotatePeriod); err != nil {
+			return err
+		}
+		fmt.Printf("Successfully generated %s key %s/keys/%s\n", keyType, profile.TransitMount, keyName)
+		signer, err := keyvault.NewTransitSigner(ctx, vaultClient, profile, keyName, 0)
+		if err != nil {
+			return err
+		}
+
+		if selfSigned {
+			cert, err := crypto.CreateSelfSignedCertificate(signer, subject, validity)
+			if err != nil {
+				return fmt.Errorf("failed to create self-signed certificate, %v", err)
+			}
+			if _, err := keyvault.WriteKV(ctx, vaultClient, profile, keyName, profile.CertificateData([]*x509.Certificate{cert}, signer.Version()), -1); err != nil {
+				return fmt.Errorf("failed to store certificate, %v", err)
+			}
+			fmt.Printf("Successfully stored self-signed certificate at %s\n", profile.KVLocation(keyName))
+			return nil
+		}
+
+		csr, err := crypto.CreateCertificateRequest(signer, subject)
+		if err != nil {
+			return fmt.Errorf("failed to create CSR, %v", err)
+		}
+		if err := os.WriteFile(csrOut, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Successfully wrote CSR to %s\n", csrOut)
+		fmt.Printf("Once issued, attach the certificate chain (leaf first), which validates it and pairs it with the key version, with\n")
+		fmt.Printf("  notation-hc-vault cert attach %s --cert_path certificate_chain.pem\n", keyName)
+		return nil
+	},
+}
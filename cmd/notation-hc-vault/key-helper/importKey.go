@@ -1,107 +1,167 @@
 package key_helper
 
 import (
+	"bytes"
 	"context"
+	gocrypto "crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/crypto"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
 	"github.com/google/tink/go/kwp/subtle"
 	"github.com/hashicorp/vault-client-go"
 	"github.com/hashicorp/vault-client-go/schema"
-	notationx509 "github.com/notaryproject/notation-core-go/x509"
 	"github.com/spf13/cobra"
-	"io/ioutil"
-	"log"
+	"golang.org/x/term"
 	"os"
 	"time"
 )
 
-var VAULTADDR, VAULTTOKEN string
+// envKeyPassphrase holds the passphrase of encrypted private keys.
+const envKeyPassphrase = "NOTATION_HC_VAULT_KEY_PASSPHRASE"
 
 func init() {
-	rootCmd.AddCommand(importKeyCmd)
-	importKeyCmd.PersistentFlags().String("key_path", "", "absolute path to the private key file")
-	importKeyCmd.PersistentFlags().String("cert_path", "", "absolute path to the certificate chain file")
-	importKeyCmd.PersistentFlags().String("key_name", "", "name of the key")
-
+	keyCmd.AddCommand(newImportKeyCmd())
+	// kept at the top level for scripts written against the original key-helper
+	legacyImportCmd := newImportKeyCmd()
+	legacyImportCmd.Hidden = true
+	rootCmd.AddCommand(legacyImportCmd)
 }
 
-var importKeyCmd = &cobra.Command{
-	Use:   "import",
-	Short: "import - a simple CLI to import key and certificates to HashiCorp Vault",
-	Long: `import - a simple CLI to import key and certificates to HashiCorp Vault
-   
-import key to Vault Transit secrets engine and certificates to Vault KV secrets engine`,
-	Run: func(cmd *cobra.Command, args []string) {
-		ctx := context.Background()
-		fmt.Println("Import key start")
-		keyPath, err := cmd.Flags().GetString("key_path")
-		if err != nil {
-			fmt.Println(err)
-		}
-		certPath, err := cmd.Flags().GetString("cert_path")
-		if err != nil {
-			fmt.Println(err)
-		}
-		keyName, err := cmd.Flags().GetString("key_name")
-		if err != nil {
-			fmt.Println(err)
-		}
-		vaultClient, err := getVaultClient(ctx)
-		if err != nil {
-			fmt.Println(err)
-		}
-		fmt.Println("Successfully got vault client")
-		wrappingKey, err := getWrappingKey(ctx, vaultClient)
-		if err != nil {
-			fmt.Println(err)
-		}
-		fmt.Println("Successfully got wrapping key")
-		ciphertext, err := wrapPrivateKey(wrappingKey, keyPath)
-		if err := importKeyToTransit(ctx, vaultClient, ciphertext, keyName); err != nil {
-			fmt.Println(err)
-		}
-		fmt.Println("Successfully imported key to transit")
-		if err := importCertToKV(ctx, vaultClient, certPath, keyName); err != nil {
-			fmt.Println(err)
-		}
-		fmt.Println("Successfully imported cert to kv")
-	},
-}
+func newImportKeyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "import a key to Vault Transit and its certificates to Vault KV",
+		Long: `import a key to Vault Transit and its certificates to Vault KV
+
+The private key is wrapped with the transit wrapping key before it is sent
+to Vault (BYOK), and the certificate chain is stored in the KV secrets engine
+at the path of the key name, paired with the imported key version.
+
+The private key may be a PEM file, encrypted or not, or a PKCS #12 bundle
+(.p12/.pfx), whose certificate chain is imported unless --cert_path is set.
+The passphrase is read from --passphrase_file, the
+NOTATION_HC_VAULT_KEY_PASSPHRASE environment variable or a prompt.
 
-func getVaultClient(ctx context.Context) (*vault.Client, error) {
-	// read addr and token from environment variables
-	VAULTADDR = os.Getenv("VAULT_ADDR")
-	if len(VAULTADDR) < 1 {
-		log.Fatal("Error loading vault address")
+If the transit key already exists, the private key is imported as a new
+version of it. The certificate chain is then replaced with a check-and-set
+write, so that it is never overwritten by a chain stored concurrently.
+
+Before anything is written to Vault, the leaf certificate must be the one of
+the private key and the chain must meet the Notary Project certificate
+requirements: leaf first, ending with its root certificate, a leaf allowed to
+sign code and valid now. --force imports the key anyway.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			keyPath, _ := cmd.Flags().GetString("key_path")
+			certPath, _ := cmd.Flags().GetString("cert_path")
+			keyName, _ := cmd.Flags().GetString("key_name")
+			passphraseFile, _ := cmd.Flags().GetString("passphrase_file")
+			force, _ := cmd.Flags().GetBool("force")
+			privateKey, bundledCerts, err := crypto.ReadPrivateKeyFile(keyPath, passphraseReader(passphraseFile, keyPath))
+			if err != nil {
+				return fmt.Errorf("failed to read private key, %v", err)
+			}
+			// the plaintext key is only needed until it is wrapped
+			defer crypto.ZeroizePrivateKey(privateKey)
+			certs := bundledCerts
+			if certPath != "" {
+				if certs, err = readCertificateChain(certPath); err != nil {
+					return err
+				}
+			} else if len(certs) == 0 {
+				return fmt.Errorf("%s holds no certificates, --cert_path is required", keyPath)
+			}
+			keyType, err := keyvault.TransitKeyType(privateKey)
+			if err != nil {
+				return err
+			}
+			if err := checkSigningCertificates(privateKey, certs, force); err != nil {
+				return err
+			}
+			profile, vaultClient, err := getClient(ctx)
+			if err != nil {
+				return err
+			}
+
+			exists := true
+			if _, err := keyvault.ReadTransitKey(ctx, vaultClient, profile, keyName); errors.Is(err, keyvault.ErrTransitKeyNotFound) {
+				exists = false
+			} else if err != nil {
+				return err
+			}
+			if exists {
+				// keep signing on the current version until the new chain
+				// is stored
+				if err := keyvault.PairCertificateChain(ctx, vaultClient, profile, keyName); err != nil {
+					return err
+				}
+			}
+			previous, err := keyvault.ReadKVIfExists(ctx, vaultClient, profile, keyName)
+			if err != nil {
+				return err
+			}
+
+			version, err := importPrivateKey(ctx, vaultClient, profile, keyName, privateKey, keyType, exists)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Successfully imported key to transit as version %d\n", version)
+
+			if _, err := keyvault.StoreCertificateChain(ctx, vaultClient, profile, keyName, certs, version, nil, previous); err != nil {
+				return fmt.Errorf("key version %d was imported but its certificate chain was not stored, %v\nstore it with: notation-hc-vault cert attach %s --cert_path <chain>", version, err, keyName)
+			}
+			fmt.Println("Successfully imported cert to kv")
+			return nil
+		},
 	}
+	cmd.Flags().String("key_path", "", "absolute path to the private key file or PKCS #12 bundle")
+	cmd.Flags().String("cert_path", "", "absolute path to the certificate chain file (default the chain of the PKCS #12 bundle)")
+	cmd.Flags().String("key_name", "", "name of the key")
+	cmd.Flags().String("passphrase_file", "", "path to a file holding the passphrase of the private key")
+	cmd.Flags().Bool("force", false, "import even if the key and certificates fail validation")
+	cmd.MarkFlagRequired("key_path")
+	cmd.MarkFlagRequired("key_name")
+	return cmd
+}
 
-	VAULTTOKEN = os.Getenv("VAULT_TOKEN")
-	if len(VAULTTOKEN) < 1 {
-		log.Fatal("Error loading vault token")
+// importPrivateKey wraps the private key, zeroizing it, and imports it as the
+// transit key called name, or as a new version of it if it exists. It returns
+// the imported key version.
+func importPrivateKey(ctx context.Context, client *vault.Client, profile *keyvault.Profile, name string, privateKey gocrypto.PrivateKey, keyType string, exists bool) (int, error) {
+	wrappingKey, err := getWrappingKey(ctx, client, profile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get wrapping key, %v", err)
 	}
-	// prepare a client with the given base address
-	vaultClient, err := vault.New(
-		vault.WithAddress(VAULTADDR),
-		vault.WithRequestTimeout(30*time.Second),
-	)
+	ciphertext, err := wrapPrivateKey(wrappingKey, privateKey)
 	if err != nil {
-		return nil, err
+		return 0, fmt.Errorf("failed to wrap private key, %v", err)
 	}
-	if err := vaultClient.SetToken(VAULTTOKEN); err != nil {
-		return nil, err
+	crypto.ZeroizePrivateKey(privateKey)
+	if exists {
+		err = importKeyVersionToTransit(ctx, client, profile, ciphertext, name)
+	} else {
+		err = importKeyToTransit(ctx, client, profile, ciphertext, name, keyType)
 	}
-
-	return vaultClient, nil
+	if err != nil {
+		return 0, fmt.Errorf("failed to import key to transit, %v", err)
+	}
+	key, err := keyvault.ReadTransitKey(ctx, client, profile, name)
+	if err != nil {
+		return 0, err
+	}
+	return key.LatestVersion, nil
 }
 
-func getWrappingKey(ctx context.Context, client *vault.Client) (string, error) {
+func getWrappingKey(ctx context.Context, client *vault.Client, profile *keyvault.Profile) (string, error) {
 	// get transit SE wrapping key
-	resp, err := client.Secrets.TransitReadWrappingKey(ctx)
+	resp, err := client.Secrets.TransitReadWrappingKey(ctx, vault.WithMountPath(profile.TransitMount))
 	if err != nil {
 		return "", err
 	}
@@ -109,21 +169,22 @@ func getWrappingKey(ctx context.Context, client *vault.Client) (string, error) {
 	return key, nil
 }
 
-func wrapPrivateKey(wrappingKey string, privateKeyPath string) (string, error) {
+func wrapPrivateKey(wrappingKey string, privateKey gocrypto.PrivateKey) (string, error) {
 	keyBlock, _ := pem.Decode([]byte(wrappingKey))
-	privateKey, err := notationx509.ReadPrivateKeyFile(privateKeyPath)
-	if err != nil {
-		return "", err
+	if keyBlock == nil {
+		return "", errors.New("malformed wrapping key")
 	}
 	pkcs8PrivateKey, err := x509.MarshalPKCS8PrivateKey(privateKey)
 	if err != nil {
 		return "", err
 	}
+	defer crypto.Zeroize(pkcs8PrivateKey)
 	parsedKey, err := x509.ParsePKIXPublicKey(keyBlock.Bytes)
 	if err != nil {
 		return "", err
 	}
 	ephemeralAESKey := make([]byte, 32)
+	defer crypto.Zeroize(ephemeralAESKey)
 	_, err = rand.Read(ephemeralAESKey)
 	if err != nil {
 		return "", err
@@ -153,7 +214,7 @@ func wrapPrivateKey(wrappingKey string, privateKeyPath string) (string, error) {
 	return base64Ciphertext, nil
 }
 
-func importKeyToTransit(ctx context.Context, client *vault.Client, ciphertext string, keyName string) error {
+func importKeyToTransit(ctx context.Context, client *vault.Client, profile *keyvault.Profile, ciphertext string, keyName string, keyType string) error {
 
 	req := schema.TransitImportKeyRequest{
 		AllowPlaintextBackup: false,
@@ -164,25 +225,70 @@ func importKeyToTransit(ctx context.Context, client *vault.Client, ciphertext st
 		Derived:              false,
 		Exportable:           false,
 		HashFunction:         "SHA256",
-		Type:                 "rsa-2048",
+		Type:                 keyType,
 	}
-	_, err := client.Secrets.TransitImportKey(ctx, keyName, req)
+	_, err := client.Secrets.TransitImportKey(ctx, keyName, req, vault.WithMountPath(profile.TransitMount))
 	return err
 }
 
-func importCertToKV(ctx context.Context, client *vault.Client, certPath string, keyName string) error {
-	certFile, err := os.Open(certPath)
+func importKeyVersionToTransit(ctx context.Context, client *vault.Client, profile *keyvault.Profile, ciphertext string, keyName string) error {
+	req := schema.TransitImportKeyVersionRequest{
+		Ciphertext:   ciphertext,
+		HashFunction: "SHA256",
+	}
+	_, err := client.Secrets.TransitImportKeyVersion(ctx, keyName, req, vault.WithMountPath(profile.TransitMount))
+	return err
+}
+
+// passphraseReader returns the passphrase source of the private key at
+// keyPath: the passphrase file if set, then the environment, then a prompt.
+func passphraseReader(passphraseFile string, keyPath string) crypto.PassphraseFunc {
+	return func() ([]byte, error) {
+		if passphraseFile != "" {
+			data, err := os.ReadFile(passphraseFile)
+			if err != nil {
+				return nil, err
+			}
+			return bytes.TrimRight(data, "\r\n"), nil
+		}
+		if passphrase, ok := os.LookupEnv(envKeyPassphrase); ok {
+			return []byte(passphrase), nil
+		}
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return nil, fmt.Errorf("%s is encrypted, set --passphrase_file or %s", keyPath, envKeyPassphrase)
+		}
+		fmt.Fprintf(os.Stderr, "Passphrase for %s: ", keyPath)
+		passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return passphrase, err
+	}
+}
+
+// readCertificateChain reads the certificates of a PEM, DER, PKCS #7 or base64
+// encoded DER file, in the order of the file.
+func readCertificateChain(certPath string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(certPath)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	bytes, err := ioutil.ReadAll(certFile)
-	data := make(map[string]interface{})
-	data["certificate"] = string(bytes)
-	req := schema.KVv2WriteRequest{
-		Data:    data,
-		Options: nil,
-		Version: 0,
+	certs, err := crypto.ParseCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificates from %s, %v", certPath, err)
 	}
-	_, err = client.Secrets.KVv2Write(ctx, keyName, req)
-	return err
+	return certs, nil
+}
+
+// checkSigningCertificates validates the certificate chain, and its pairing
+// with key if not nil, before it is stored. With force, the problems found
+// are only reported as a warning.
+func checkSigningCertificates(key gocrypto.PublicKey, certs []*x509.Certificate, force bool) error {
+	err := crypto.ValidateSigningCertificates(key, certs, time.Now())
+	if err == nil {
+		return nil
+	}
+	if force {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return nil
+	}
+	return fmt.Errorf("invalid signing certificates, %v\nuse --force to store them anyway", err)
 }
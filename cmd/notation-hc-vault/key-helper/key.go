@@ -0,0 +1,200 @@
+package key_helper
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/spf13/cobra"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	keyCmd.AddCommand(listKeysCmd, describeKeyCmd, deleteKeyCmd)
+	listKeysCmd.Flags().StringP("output", "o", "table", "output format, table or json")
+	listKeysCmd.Flags().Bool("all_profiles", false, "list the keys of every profile in the config file")
+	deleteKeyCmd.Flags().Bool("yes", false, "do not ask for confirmation")
+	deleteKeyCmd.Flags().Bool("keep_cert", false, "keep the certificate chain in KV")
+}
+
+var listKeysCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the transit keys and the certificates stored for them",
+	Long: `list the transit keys and the certificates stored for them
+
+For each key in the transit mount, reports the key type, latest version and
+exportability along with the subject, issuer and expiry of the leaf
+certificate stored at the KV path of the same name, and whether the leaf
+certificate certifies the latest key version.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		output, _ := cmd.Flags().GetString("output")
+		allProfiles, _ := cmd.Flags().GetBool("all_profiles")
+		if output != "table" && output != "json" {
+			return fmt.Errorf("unsupported output format %q, must be table or json", output)
+		}
+
+		infos, err := inventoryAll(ctx, allProfiles)
+		if err != nil {
+			return err
+		}
+
+		if output == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "    ")
+			return encoder.Encode(infos)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PROFILE\tKEY\tTYPE\tVERSION\tEXPORTABLE\tSUBJECT\tISSUER\tEXPIRES\tMATCH")
+		for _, info := range infos {
+			keyType, version := info.Type, strconv.Itoa(info.LatestVersion)
+			subject, issuer, expires, match := "-", "-", "-", "no certificate"
+			if info.KeyError != "" {
+				keyType, version, match = "-", "-", "unreadable key"
+			}
+			if info.CertError == "" {
+				subject, issuer, expires = info.Subject, info.Issuer, info.NotAfter.Format("2006-01-02")
+				switch {
+				case info.KeyError != "":
+					// no key versions to match the certificate against
+				case info.Match:
+					match = "yes"
+				case info.CertKeyVersion != 0:
+					match = fmt.Sprintf("no, certifies v%d", info.CertKeyVersion)
+				default:
+					match = "no"
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\t%s\t%s\t%s\n", info.Profile, info.Key, keyType, version, info.Exportable, subject, issuer, expires, match)
+		}
+		return w.Flush()
+	},
+}
+
+// inventoryAll returns the inventory of the selected profile, or of every
+// profile in the config file if allProfiles is set.
+func inventoryAll(ctx context.Context, allProfiles bool) ([]*keyvault.KeyInfo, error) {
+	names := []string{profileName}
+	if allProfiles {
+		config, err := keyvault.ResolveConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if config != nil {
+			names = config.ProfileNames()
+		}
+	}
+	infos := []*keyvault.KeyInfo{}
+	for _, name := range names {
+		profile, err := keyvault.LoadProfileFrom(configPath, name)
+		if err != nil {
+			return nil, err
+		}
+		vaultClient, err := keyvault.NewClient(ctx, profile)
+		if err != nil {
+			return nil, err
+		}
+		profileInfos, err := keyvault.Inventory(ctx, vaultClient, profile)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %v", profile.Name, err)
+		}
+		infos = append(infos, profileInfos...)
+	}
+	return infos, nil
+}
+
+var describeKeyCmd = &cobra.Command{
+	Use:   "describe <key>",
+	Short: "show a transit key and the certificate chain stored for it",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		profile, vaultClient, err := getClient(ctx)
+		if err != nil {
+			return err
+		}
+		key, err := keyvault.ReadTransitKey(ctx, vaultClient, profile, args[0])
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Key:\t%s/keys/%s\n", profile.TransitMount, key.Name)
+		fmt.Fprintf(w, "Type:\t%s\n", key.Type)
+		fmt.Fprintf(w, "Latest version:\t%d\n", key.LatestVersion)
+		fmt.Fprintf(w, "Exportable:\t%t\n", key.Exportable)
+		if key.AutoRotatePeriod > 0 {
+			fmt.Fprintf(w, "Auto rotate period:\t%v\n", key.AutoRotatePeriod)
+		}
+		for _, version := range key.Versions() {
+			fmt.Fprintf(w, "Version %d:\tcreated %s\n", version, key.CreationTimes[version].Format(time.RFC3339))
+		}
+
+		chain, err := keyvault.ReadCertificateChain(ctx, vaultClient, profile, key.Name)
+		if err != nil {
+			fmt.Fprintf(w, "Certificate:\t%v\n", err)
+		} else {
+			leaf := chain.Certificates[0]
+			fmt.Fprintf(w, "Certificate:\t%s\n", profile.KVLocation(key.Name))
+			fmt.Fprintf(w, "Subject:\t%s\n", leaf.Subject)
+			fmt.Fprintf(w, "Issuer:\t%s\n", leaf.Issuer)
+			fmt.Fprintf(w, "Expires:\t%s\n", leaf.NotAfter.Format(time.RFC3339))
+			if warning := keyvault.ExpiryWarning(chain.Certificates, profile.ExpiryWarningWindow(), time.Now()); warning != "" {
+				fmt.Fprintf(w, "Warning:\t%s\n", warning)
+			}
+			if chain.KeyVersion != 0 {
+				fmt.Fprintf(w, "Signing version:\t%d (paired with the certificate)\n", chain.KeyVersion)
+			} else {
+				fmt.Fprintf(w, "Signing version:\t%d (latest)\n", key.LatestVersion)
+			}
+		}
+		return w.Flush()
+	},
+}
+
+var deleteKeyCmd = &cobra.Command{
+	Use:   "delete <key>",
+	Short: "permanently delete a transit key and its certificate chain",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		yes, _ := cmd.Flags().GetBool("yes")
+		keepCert, _ := cmd.Flags().GetBool("keep_cert")
+		profile, vaultClient, err := getClient(ctx)
+		if err != nil {
+			return err
+		}
+		if !yes {
+			if !confirm(fmt.Sprintf("Permanently delete %s/keys/%s? Signatures made with it can no longer be produced.", profile.TransitMount, args[0])) {
+				return errors.New("aborted")
+			}
+		}
+		if err := keyvault.DeleteTransitKey(ctx, vaultClient, profile, args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Successfully deleted %s/keys/%s\n", profile.TransitMount, args[0])
+		if keepCert {
+			return nil
+		}
+		if err := keyvault.DeleteKV(ctx, vaultClient, profile, args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Successfully deleted %s\n", profile.KVLocation(args[0]))
+		return nil
+	},
+}
+
+// confirm asks a yes/no question on stdin.
+func confirm(prompt string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,245 @@
+package key_helper
+
+import (
+	"context"
+	gocrypto "crypto"
+	"crypto/x509"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/crypto"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+func init() {
+	keyCmd.AddCommand(importManifestCmd)
+	importManifestCmd.Flags().Bool("dry_run", false, "validate the manifest and report what would be imported")
+	importManifestCmd.Flags().Bool("keep_going", false, "import the remaining entries after a failed one")
+	importManifestCmd.Flags().Bool("force", false, "import even if keys and certificates fail validation")
+}
+
+// results of the import of a manifest entry
+const (
+	statusImported        = "imported"
+	statusImportedVersion = "imported version"
+	statusChainStored     = "chain stored"
+	statusUpToDate        = "up to date"
+	statusNotAttempted    = "not attempted"
+)
+
+var importManifestCmd = &cobra.Command{
+	Use:   "import-manifest <manifest>",
+	Short: "import the keys listed in a YAML or JSON manifest",
+	Long: `import the keys listed in a YAML or JSON manifest
+
+Every entry of the manifest is validated first, the same way key import
+validates a key, and nothing is imported unless all of them pass. The entries
+are then imported in order, each reporting its result:
+
+  imported           the transit key was created with the key
+  imported version   the key was added as a new version of the transit key
+  chain stored       the key was imported before, only its chain was stored
+  up to date         the key and its chain were imported before
+
+The import stops at the first failure unless --keep_going is set. Entries
+already imported are detected from Vault, so that re-running the manifest
+resumes an interrupted import.
+
+Example manifest:
+
+  defaults:
+    passphraseFile: /run/secrets/key-passphrase
+    metadata:
+      migratedFrom: hsm-01
+  keys:
+    - name: release
+      keyPath: keys/release.p12
+      type: ecdsa-p256
+    - name: nightly
+      keyPath: keys/nightly.key
+      certPath: chains/nightly.pem
+      transitMount: transit-ci
+      metadata:
+        owner: ci-team`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		dryRun, _ := cmd.Flags().GetBool("dry_run")
+		keepGoing, _ := cmd.Flags().GetBool("keep_going")
+		force, _ := cmd.Flags().GetBool("force")
+		manifest, err := keyvault.LoadManifest(args[0])
+		if err != nil {
+			return err
+		}
+		profile, err := getProfile()
+		if err != nil {
+			return err
+		}
+
+		imports := make([]*manifestImport, 0, len(manifest.Keys))
+		defer func() {
+			for _, imp := range imports {
+				crypto.ZeroizePrivateKey(imp.privateKey)
+			}
+		}()
+		failed := 0
+		for i, entry := range manifest.Keys {
+			imp, err := loadManifestEntry(entry, profile, force)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "entry %d (%s): %v\n", i+1, entry.Name, err)
+				failed++
+				continue
+			}
+			imports = append(imports, imp)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d entries failed validation, nothing was imported", failed, len(manifest.Keys))
+		}
+
+		vaultClient, err := keyvault.NewClient(ctx, profile)
+		if err != nil {
+			return err
+		}
+		counts := make(map[string]int)
+		for i, imp := range imports {
+			prefix := fmt.Sprintf("[%d/%d] %s/keys/%s:", i+1, len(imports), imp.profile.TransitMount, imp.entry.Name)
+			status, version, err := imp.run(ctx, vaultClient, dryRun)
+			if err != nil {
+				fmt.Printf("%s failed, %v\n", prefix, err)
+				failed++
+				if !keepGoing {
+					counts[statusNotAttempted] = len(imports) - i - 1
+					break
+				}
+				continue
+			}
+			counts[status]++
+			if dryRun && status != statusUpToDate {
+				status = "would be " + status
+			}
+			if version != 0 {
+				status = fmt.Sprintf("%s (version %d)", status, version)
+			}
+			fmt.Println(prefix, status)
+		}
+
+		summary := fmt.Sprintf("%d failed", failed)
+		for _, status := range []string{statusImported, statusImportedVersion, statusChainStored, statusUpToDate, statusNotAttempted} {
+			if counts[status] > 0 {
+				summary += fmt.Sprintf(", %d %s", counts[status], status)
+			}
+		}
+		if dryRun {
+			summary = "Dry run: " + summary
+		}
+		fmt.Println(summary)
+		if failed > 0 {
+			return fmt.Errorf("%d of %d entries failed, re-run the manifest to resume", failed, len(imports))
+		}
+		return nil
+	},
+}
+
+// manifestImport is a validated manifest entry, ready to be imported.
+type manifestImport struct {
+	entry      *keyvault.ManifestEntry
+	profile    *keyvault.Profile
+	privateKey gocrypto.PrivateKey
+	publicKey  gocrypto.PublicKey
+	keyType    string
+	certs      []*x509.Certificate
+}
+
+func loadManifestEntry(entry *keyvault.ManifestEntry, base *keyvault.Profile, force bool) (*manifestImport, error) {
+	imp := &manifestImport{entry: entry, profile: entry.Profile(base)}
+	if err := entry.CheckMetadata(imp.profile); err != nil {
+		return nil, err
+	}
+	privateKey, bundledCerts, err := crypto.ReadPrivateKeyFile(entry.KeyPath, passphraseReader(entry.PassphraseFile, entry.KeyPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key, %v", err)
+	}
+	imp.privateKey = privateKey
+	if err := imp.validate(bundledCerts, force); err != nil {
+		crypto.ZeroizePrivateKey(privateKey)
+		return nil, err
+	}
+	return imp, nil
+}
+
+func (imp *manifestImport) validate(bundledCerts []*x509.Certificate, force bool) error {
+	signer, ok := imp.privateKey.(gocrypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported private key type %T", imp.privateKey)
+	}
+	imp.publicKey = signer.Public()
+	keyType, err := keyvault.TransitKeyType(imp.privateKey)
+	if err != nil {
+		return err
+	}
+	if imp.entry.Type != "" && imp.entry.Type != keyType {
+		return fmt.Errorf("the private key is a %s key, not %s", keyType, imp.entry.Type)
+	}
+	imp.keyType = keyType
+	imp.certs = bundledCerts
+	if imp.entry.CertPath != "" {
+		if imp.certs, err = readCertificateChain(imp.entry.CertPath); err != nil {
+			return err
+		}
+	} else if len(imp.certs) == 0 {
+		return fmt.Errorf("%s holds no certificates, certPath is required", imp.entry.KeyPath)
+	}
+	if err := crypto.ValidateSigningCertificates(imp.privateKey, imp.certs, time.Now()); err != nil {
+		if !force {
+			return fmt.Errorf("invalid signing certificates, %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", imp.entry.Name, err)
+	}
+	return nil
+}
+
+// run imports what is missing of the entry in Vault and returns its status
+// and key version. With dryRun, it only reports what it would do.
+func (imp *manifestImport) run(ctx context.Context, client *vault.Client, dryRun bool) (string, int, error) {
+	name := imp.entry.Name
+	state, err := keyvault.ReadImportState(ctx, client, imp.profile, name, imp.publicKey, imp.certs, imp.entry.Metadata)
+	if err != nil {
+		return "", 0, err
+	}
+	status, version := statusChainStored, state.KeyVersion
+	switch {
+	case state.ChainStored:
+		return statusUpToDate, version, nil
+	case version != 0:
+	case state.KeyExists:
+		status = statusImportedVersion
+	default:
+		status = statusImported
+	}
+	if dryRun {
+		return status, version, nil
+	}
+
+	previous := state.Secret
+	if version == 0 {
+		if state.KeyExists {
+			// keep signing on the current version until the new chain is
+			// stored
+			if err := keyvault.PairCertificateChain(ctx, client, imp.profile, name); err != nil {
+				return "", 0, err
+			}
+			if previous, err = keyvault.ReadKVIfExists(ctx, client, imp.profile, name); err != nil {
+				return "", 0, err
+			}
+		}
+		if version, err = importPrivateKey(ctx, client, imp.profile, name, imp.privateKey, imp.keyType, state.KeyExists); err != nil {
+			return "", 0, err
+		}
+	}
+	if _, err := keyvault.StoreCertificateChain(ctx, client, imp.profile, name, imp.certs, version, imp.entry.Metadata, previous); err != nil {
+		return "", 0, fmt.Errorf("key version %d was imported but its certificate chain was not stored, %v", version, err)
+	}
+	return status, version, nil
+}
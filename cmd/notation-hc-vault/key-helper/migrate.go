@@ -0,0 +1,167 @@
+package key_helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/crypto"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/notaryproject/notation-go/config"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+func init() {
+	keyCmd.AddCommand(migrateLocalCmd)
+	migrateLocalCmd.Flags().Bool("all", false, "migrate every local key of notation")
+	migrateLocalCmd.Flags().String("key_name", "", "name of the transit key, when migrating a single key (default the notation key name)")
+	migrateLocalCmd.Flags().StringToString("plugin_config", nil, "plugin config of the migrated keys, as key=value pairs (default the --config and --profile flags if set)")
+	migrateLocalCmd.Flags().Bool("dry_run", false, "validate the local keys and report what would be migrated")
+	migrateLocalCmd.Flags().Bool("force", false, "migrate even if keys and certificates fail validation")
+}
+
+var migrateLocalCmd = &cobra.Command{
+	Use:   "migrate-local [notation key name]...",
+	Short: "move local signing keys of notation to Vault",
+	Long: `move local signing keys of notation to Vault
+
+Imports the selected local keys of notation's signingkeys.json, with their
+certificate chains, the same way key import does, and rewrites their entries
+to sign with this plugin. The notation key names, and the default key, are
+kept. The original signingkeys.json is backed up next to it first. The local
+key files are left in place, delete them once the migration is verified.
+
+Keys already imported are detected from Vault, so that the command can be
+re-run after a failure.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		all, _ := cmd.Flags().GetBool("all")
+		keyName, _ := cmd.Flags().GetString("key_name")
+		pluginConfig, _ := cmd.Flags().GetStringToString("plugin_config")
+		dryRun, _ := cmd.Flags().GetBool("dry_run")
+		force, _ := cmd.Flags().GetBool("force")
+		if all == (len(args) > 0) {
+			return errors.New("either name the keys to migrate or set --all")
+		}
+		if keyName != "" && len(args) != 1 {
+			return errors.New("--key_name requires migrating a single key")
+		}
+		if len(pluginConfig) == 0 {
+			pluginConfig = defaultPluginConfig()
+		}
+
+		signingKeys, err := loadSigningKeys()
+		if err != nil {
+			return err
+		}
+		indexes, err := selectLocalKeys(signingKeys, args)
+		if err != nil {
+			return err
+		}
+		profile, err := getProfile()
+		if err != nil {
+			return err
+		}
+
+		imports := make([]*manifestImport, 0, len(indexes))
+		defer func() {
+			for _, imp := range imports {
+				crypto.ZeroizePrivateKey(imp.privateKey)
+			}
+		}()
+		for _, i := range indexes {
+			local := signingKeys.Keys[i]
+			entry := &keyvault.ManifestEntry{
+				Name:     local.Name,
+				KeyPath:  local.KeyPath,
+				CertPath: local.CertificatePath,
+			}
+			if keyName != "" {
+				entry.Name = keyName
+			}
+			imp, err := loadManifestEntry(entry, profile, force)
+			if err != nil {
+				return fmt.Errorf("local key %q: %v", local.Name, err)
+			}
+			imports = append(imports, imp)
+		}
+
+		vaultClient, err := keyvault.NewClient(ctx, profile)
+		if err != nil {
+			return err
+		}
+		var failures []string
+		migrated := 0
+		for n, imp := range imports {
+			local := &signingKeys.Keys[indexes[n]]
+			status, version, err := imp.run(ctx, vaultClient, dryRun)
+			if err != nil {
+				fmt.Printf("%s: failed, %v\n", local.Name, err)
+				failures = append(failures, local.Name)
+				continue
+			}
+			if dryRun {
+				fmt.Printf("%s: would be migrated to %s/keys/%s (%s)\n", local.Name, imp.profile.TransitMount, imp.entry.Name, status)
+				continue
+			}
+			fmt.Printf("%s: migrated to %s/keys/%s version %d (%s)\n", local.Name, imp.profile.TransitMount, imp.entry.Name, version, status)
+			local.X509KeyPair = nil
+			local.ExternalKey = &config.ExternalKey{
+				ID:           imp.entry.Name,
+				PluginName:   notationPluginName,
+				PluginConfig: pluginConfig,
+			}
+			migrated++
+		}
+
+		if migrated > 0 {
+			backup, err := backupSigningKeys()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Backed up signingkeys.json to %s\n", backup)
+			if err := saveSigningKeys(signingKeys); err != nil {
+				return err
+			}
+			fmt.Printf("Updated %d signing keys to use the %s plugin\n", migrated, notationPluginName)
+		}
+		if len(failures) > 0 {
+			return fmt.Errorf("failed to migrate %s, re-run to retry", strings.Join(failures, ", "))
+		}
+		return nil
+	},
+}
+
+// selectLocalKeys returns the indexes of the named local keys, or of every
+// local key if names is empty.
+func selectLocalKeys(signingKeys *config.SigningKeys, names []string) ([]int, error) {
+	var indexes []int
+	if len(names) == 0 {
+		for i, key := range signingKeys.Keys {
+			if key.X509KeyPair != nil {
+				indexes = append(indexes, i)
+			}
+		}
+		if len(indexes) == 0 {
+			return nil, errors.New("notation has no local signing keys")
+		}
+		return indexes, nil
+	}
+	for _, name := range names {
+		index := -1
+		for i, key := range signingKeys.Keys {
+			if key.Name == name {
+				index = i
+				break
+			}
+		}
+		switch {
+		case index < 0:
+			return nil, fmt.Errorf("notation has no signing key %q", name)
+		case signingKeys.Keys[index].X509KeyPair == nil:
+			return nil, fmt.Errorf("notation signing key %q is not a local key", name)
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
@@ -0,0 +1,141 @@
+package key_helper
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/notaryproject/notation-core-go/testhelper"
+	"github.com/notaryproject/notation-go/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSelectLocalKeys(t *testing.T) {
+	signingKeys := &config.SigningKeys{Keys: []config.KeySuite{
+		{Name: "local", X509KeyPair: &config.X509KeyPair{KeyPath: "local.key", CertificatePath: "local.crt"}},
+		{Name: "remote", ExternalKey: &config.ExternalKey{ID: "remote", PluginName: "other"}},
+	}}
+	tests := []struct {
+		name  string
+		names []string
+		want  []int
+		err   string
+	}{
+		{name: "all", want: []int{0}},
+		{name: "named", names: []string{"local"}, want: []int{0}},
+		{name: "unknown", names: []string{"local", "missing"}, err: `notation has no signing key "missing"`},
+		{name: "not local", names: []string{"remote"}, err: `notation signing key "remote" is not a local key`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexes, err := selectLocalKeys(signingKeys, tt.names)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(indexes) != len(tt.want) || indexes[0] != tt.want[0] {
+				t.Fatalf("expected %v, got %v", tt.want, indexes)
+			}
+		})
+	}
+
+	if _, err := selectLocalKeys(&config.SigningKeys{Keys: signingKeys.Keys[1:]}, nil); err == nil {
+		t.Fatal("expected an error without local keys")
+	}
+}
+
+func TestMigrateLocalAllWithNames(t *testing.T) {
+	if err := migrateLocalCmd.Flags().Set("all", "true"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { migrateLocalCmd.Flags().Set("all", "false") })
+	err := migrateLocalCmd.RunE(migrateLocalCmd, []string{"local"})
+	if err == nil || err.Error() != "either name the keys to migrate or set --all" {
+		t.Fatalf("expected --all with names to be refused, got %v", err)
+	}
+}
+
+func TestMigrateLocal(t *testing.T) {
+	leaf, root := testhelper.GetRSALeafCertificate(), testhelper.GetRSARootCertificate()
+	publicKey, err := x509.MarshalPKIXPublicKey(leaf.PrivateKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "good" is already imported, so that only the notation config is
+	// rewritten. "broken" is not, and its import fails on the fake Vault.
+	server := newFakeVault(t, map[string]any{
+		"/v1/transit/keys/good": map[string]any{
+			"type":           "rsa-3072",
+			"latest_version": 1,
+			"keys": map[string]any{
+				"1": map[string]any{"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}))},
+			},
+		},
+		"/v1/secret/data/good": map[string]any{
+			"data":     map[string]any{"certificate": string(chainPEM(leaf.Cert, root.Cert)), "key_version": 1},
+			"metadata": map[string]any{"version": 1},
+		},
+	})
+	pluginConfigPath := setupConfig(t, server.URL)
+	keyPath, certPath := writeLocalKey(t)
+	defaultKey := "good"
+	remote := config.KeySuite{Name: "remote", ExternalKey: &config.ExternalKey{ID: "remote", PluginName: "other"}}
+	signingKeysPath := setupNotationConfig(t, &config.SigningKeys{
+		Default: &defaultKey,
+		Keys: []config.KeySuite{
+			{Name: "good", X509KeyPair: &config.X509KeyPair{KeyPath: keyPath, CertificatePath: certPath}},
+			{Name: "broken", X509KeyPair: &config.X509KeyPair{KeyPath: keyPath, CertificatePath: certPath}},
+			remote,
+		},
+	})
+	original, err := os.ReadFile(signingKeysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = migrateLocalCmd.RunE(migrateLocalCmd, []string{"good", "broken"})
+	if err == nil || !strings.Contains(err.Error(), "failed to migrate broken") {
+		t.Fatalf("expected the broken key to fail, got %v", err)
+	}
+
+	signingKeys := reloadSigningKeys(t)
+	if signingKeys.Default == nil || *signingKeys.Default != "good" {
+		t.Errorf("expected the default key to be kept, got %v", signingKeys.Default)
+	}
+	if len(signingKeys.Keys) != 3 {
+		t.Fatalf("expected 3 signing keys, got %d", len(signingKeys.Keys))
+	}
+	good := findKey(signingKeys, "good")
+	if good == nil || good.X509KeyPair != nil || good.ExternalKey == nil {
+		t.Fatalf("expected good to be rewritten to an external key, got %+v", good)
+	}
+	absConfigPath, _ := filepath.Abs(pluginConfigPath)
+	if good.ID != "good" || good.PluginName != notationPluginName || good.PluginConfig["config"] != absConfigPath {
+		t.Errorf("unexpected external key %+v", good.ExternalKey)
+	}
+	broken := findKey(signingKeys, "broken")
+	if broken == nil || broken.ExternalKey != nil || broken.X509KeyPair == nil || broken.KeyPath != keyPath {
+		t.Errorf("expected broken to stay a local key, got %+v", broken)
+	}
+	if other := findKey(signingKeys, "remote"); other == nil || other.ExternalKey == nil || other.PluginName != "other" {
+		t.Errorf("expected remote to be left alone, got %+v", other)
+	}
+
+	backups, err := filepath.Glob(signingKeysPath + ".*.bak")
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("expected a single backup, got %v %v", backups, err)
+	}
+	backup, err := os.ReadFile(backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(backup) != string(original) {
+		t.Errorf("expected the backup to hold the original signingkeys.json, got %s", backup)
+	}
+}
@@ -0,0 +1,94 @@
+package key_helper
+
+import (
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/notaryproject/notation-go/config"
+	"github.com/notaryproject/notation-go/dir"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// notationPluginName is the plugin name reported by get-plugin-metadata,
+	// which notation looks up in signingkeys.json.
+	notationPluginName = "hc-vault"
+	// envNotationConfig overrides the notation configuration directory.
+	envNotationConfig = "NOTATION_CONFIG"
+)
+
+// notationConfigDir returns notation's configuration directory,
+// NOTATION_CONFIG if set.
+func notationConfigDir() string {
+	if notationConfig := os.Getenv(envNotationConfig); notationConfig != "" {
+		dir.UserConfigDir = notationConfig
+	}
+	return dir.UserConfigDir
+}
+
+// loadSigningKeys reads the signingkeys.json of notationConfigDir. A missing
+// file yields no keys.
+func loadSigningKeys() (*config.SigningKeys, error) {
+	notationConfigDir()
+	signingKeys, err := config.LoadSigningKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notation signing keys, %v", err)
+	}
+	return signingKeys, nil
+}
+
+// saveSigningKeys writes notation's signingkeys.json where loadSigningKeys
+// read it.
+func saveSigningKeys(signingKeys *config.SigningKeys) error {
+	if err := signingKeys.Save(); err != nil {
+		return fmt.Errorf("failed to save notation signing keys, %v", err)
+	}
+	return nil
+}
+
+// backupSigningKeys copies signingkeys.json to a timestamped file next to it
+// and returns the path of the copy.
+func backupSigningKeys() (string, error) {
+	return backupNotationFile(dir.PathSigningKeys)
+}
+
+// backupNotationFile copies the notation configuration file name to a
+// timestamped file next to it and returns the path of the copy.
+func backupNotationFile(name string) (string, error) {
+	path, err := dir.ConfigFS().SysPath(name)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to back up %s, %v", name, err)
+	}
+	backup := fmt.Sprintf("%s.%s.bak", path, time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.WriteFile(backup, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to back up %s, %v", name, err)
+	}
+	return backup, nil
+}
+
+// defaultPluginConfig returns the plugin config selecting the same config
+// file and profile as the --config and --profile flags, for notation to
+// reach the same Vault.
+func defaultPluginConfig() map[string]string {
+	pluginConfig := make(map[string]string)
+	if configPath != "" {
+		// notation runs the plugin from another working directory
+		if path, err := filepath.Abs(configPath); err == nil {
+			pluginConfig[keyvault.PluginConfigFile] = path
+		} else {
+			pluginConfig[keyvault.PluginConfigFile] = configPath
+		}
+	}
+	if profileName != "" {
+		pluginConfig[keyvault.PluginConfigProfile] = profileName
+	}
+	if len(pluginConfig) == 0 {
+		return nil
+	}
+	return pluginConfig
+}
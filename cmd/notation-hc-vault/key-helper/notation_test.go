@@ -0,0 +1,115 @@
+package key_helper
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/notaryproject/notation-core-go/testhelper"
+	"github.com/notaryproject/notation-go/config"
+	"github.com/notaryproject/notation-go/dir"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newFakeVault serves the data of responses by request path, and 404 for
+// the other paths.
+func newFakeVault(t *testing.T, responses map[string]any) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": resp})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// setupConfig writes a plugin config with a single profile for the Vault at
+// address and selects it like the --config flag does.
+func setupConfig(t *testing.T, address string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := fmt.Sprintf(`{"profiles": {"dev": {"address": %q, "auth": {"token": "root"}}}}`, address)
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	previousConfig, previousProfile := configPath, profileName
+	configPath, profileName = path, ""
+	t.Cleanup(func() { configPath, profileName = previousConfig, previousProfile })
+	t.Setenv(keyvault.EnvCacheDir, t.TempDir())
+	return path
+}
+
+// setupNotationConfig points NOTATION_CONFIG at a temporary directory
+// holding signingKeys, and returns the path of its signingkeys.json.
+func setupNotationConfig(t *testing.T, signingKeys *config.SigningKeys) string {
+	t.Helper()
+	previous := dir.UserConfigDir
+	t.Cleanup(func() { dir.UserConfigDir = previous })
+	t.Setenv(envNotationConfig, t.TempDir())
+	notationConfigDir()
+	if err := signingKeys.Save(); err != nil {
+		t.Fatal(err)
+	}
+	path, err := dir.ConfigFS().SysPath(dir.PathSigningKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeLocalKey writes the notation test RSA key and its chain as a local
+// notation key, and returns their paths.
+func writeLocalKey(t *testing.T) (string, string) {
+	t.Helper()
+	leaf, root := testhelper.GetRSALeafCertificate(), testhelper.GetRSARootCertificate()
+	der, err := x509.MarshalPKCS8PrivateKey(leaf.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "local.key")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(t.TempDir(), "local.crt")
+	if err := os.WriteFile(certPath, chainPEM(leaf.Cert, root.Cert), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return keyPath, certPath
+}
+
+func chainPEM(certs ...*x509.Certificate) []byte {
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return data
+}
+
+// reloadSigningKeys reads back the signingkeys.json written by a command.
+func reloadSigningKeys(t *testing.T) *config.SigningKeys {
+	t.Helper()
+	signingKeys, err := loadSigningKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingKeys
+}
+
+func findKey(signingKeys *config.SigningKeys, name string) *config.KeySuite {
+	for i := range signingKeys.Keys {
+		if signingKeys.Keys[i].Name == name {
+			return &signingKeys.Keys[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package key_helper
+
+import (
+	"context"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/signature"
+	"github.com/notaryproject/notation-go/config"
+	"github.com/notaryproject/notation-go/plugin/proto"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	keyCmd.AddCommand(registerKeyCmd)
+	registerKeyCmd.Flags().String("name", "", "name of the key in notation (default the key ID)")
+	registerKeyCmd.Flags().Bool("default", false, "make the key notation's default signing key")
+	registerKeyCmd.Flags().StringToString("plugin_config", nil, "plugin config of the key, as key=value pairs (default the --config and --profile flags if set)")
+}
+
+var registerKeyCmd = &cobra.Command{
+	Use:   "register <key id>",
+	Short: "add a Vault key to notation's signing keys",
+	Long: `add a Vault key to notation's signing keys
+
+Writes the entry signing with this plugin and the key ID, a key name or alias,
+in notation's signingkeys.json, as "notation key add --plugin hc-vault" does.
+An existing entry of the same name using this plugin is updated. The
+signingkeys.json of NOTATION_CONFIG is used if set.
+
+The key is described first, as notation does before signing, so that a key
+without a usable certificate chain is not registered.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		keyID := args[0]
+		name, _ := cmd.Flags().GetString("name")
+		markDefault, _ := cmd.Flags().GetBool("default")
+		pluginConfig, _ := cmd.Flags().GetStringToString("plugin_config")
+		if name == "" {
+			name = keyID
+		}
+		if len(pluginConfig) == 0 {
+			pluginConfig = defaultPluginConfig()
+		}
+		return registerNotationKey(ctx, keyID, name, pluginConfig, markDefault)
+	},
+}
+
+// registerNotationKey adds or updates the notation signing key name signing
+// with keyID through this plugin, once the key is described successfully.
+func registerNotationKey(ctx context.Context, keyID string, name string, pluginConfig map[string]string, markDefault bool) error {
+	signingKeys, err := loadSigningKeys()
+	if err != nil {
+		return err
+	}
+	index := -1
+	for i, key := range signingKeys.Keys {
+		if key.Name == name {
+			index = i
+			break
+		}
+	}
+	if index >= 0 && (signingKeys.Keys[index].ExternalKey == nil || signingKeys.Keys[index].PluginName != notationPluginName) {
+		return fmt.Errorf("notation signing key %q does not use the %s plugin, choose another --name or migrate it with: notation-hc-vault key migrate-local %s", name, notationPluginName, name)
+	}
+
+	resp, err := signature.DescribeKey(ctx, &proto.DescribeKeyRequest{
+		ContractVersion: proto.ContractVersion,
+		KeyID:           keyID,
+		PluginConfig:    pluginConfig,
+	})
+	if err != nil {
+		return fmt.Errorf("key %q is not usable for signing, %v", keyID, err)
+	}
+
+	key := config.KeySuite{
+		Name: name,
+		ExternalKey: &config.ExternalKey{
+			ID:           keyID,
+			PluginName:   notationPluginName,
+			PluginConfig: pluginConfig,
+		},
+	}
+	if index >= 0 {
+		signingKeys.Keys[index] = key
+	} else {
+		signingKeys.Keys = append(signingKeys.Keys, key)
+	}
+	if markDefault {
+		signingKeys.Default = &name
+	}
+	if err := saveSigningKeys(signingKeys); err != nil {
+		return err
+	}
+	verb := "Added"
+	if index >= 0 {
+		verb = "Updated"
+	}
+	fmt.Printf("%s notation signing key %q for %s key %s\n", verb, name, resp.KeySpec, keyID)
+	if signingKeys.Default != nil && *signingKeys.Default == name {
+		fmt.Printf("%q is the default signing key\n", name)
+	}
+	return nil
+}
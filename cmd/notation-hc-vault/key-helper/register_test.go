@@ -0,0 +1,86 @@
+package key_helper
+
+import (
+	"context"
+	"github.com/notaryproject/notation-core-go/testhelper"
+	"github.com/notaryproject/notation-go/config"
+	"strings"
+	"testing"
+)
+
+func TestRegisterNotationKey(t *testing.T) {
+	leaf, root := testhelper.GetRSALeafCertificate(), testhelper.GetRSARootCertificate()
+	server := newFakeVault(t, map[string]any{
+		"/v1/secret/data/release": map[string]any{
+			"data":     map[string]any{"certificate": string(chainPEM(leaf.Cert, root.Cert))},
+			"metadata": map[string]any{"version": 1},
+		},
+	})
+	pluginConfigPath := setupConfig(t, server.URL)
+	keyPath, certPath := writeLocalKey(t)
+	defaultKey := "local"
+	setupNotationConfig(t, &config.SigningKeys{
+		Default: &defaultKey,
+		Keys: []config.KeySuite{
+			{Name: "local", X509KeyPair: &config.X509KeyPair{KeyPath: keyPath, CertificatePath: certPath}},
+			{Name: "remote", ExternalKey: &config.ExternalKey{ID: "remote", PluginName: "other"}},
+		},
+	})
+	ctx := context.Background()
+
+	// add
+	if err := registerNotationKey(ctx, "release", "release", map[string]string{"config": pluginConfigPath}, false); err != nil {
+		t.Fatal(err)
+	}
+	signingKeys := reloadSigningKeys(t)
+	release := findKey(signingKeys, "release")
+	if len(signingKeys.Keys) != 3 || release == nil || release.ExternalKey == nil {
+		t.Fatalf("expected release to be added, got %+v", signingKeys.Keys)
+	}
+	if release.ID != "release" || release.PluginName != notationPluginName || release.PluginConfig["config"] != pluginConfigPath {
+		t.Errorf("unexpected external key %+v", release.ExternalKey)
+	}
+	if signingKeys.Default == nil || *signingKeys.Default != "local" {
+		t.Errorf("expected the default key to be kept, got %v", signingKeys.Default)
+	}
+
+	// update the same name in place, and make it the default
+	pluginConfig := map[string]string{"config": pluginConfigPath, "profile": "dev"}
+	if err := registerNotationKey(ctx, "release", "release", pluginConfig, true); err != nil {
+		t.Fatal(err)
+	}
+	signingKeys = reloadSigningKeys(t)
+	release = findKey(signingKeys, "release")
+	if len(signingKeys.Keys) != 3 || release == nil || release.ExternalKey == nil {
+		t.Fatalf("expected release to be updated in place, got %+v", signingKeys.Keys)
+	}
+	if release.PluginConfig["profile"] != "dev" {
+		t.Errorf("expected the plugin config to be updated, got %v", release.PluginConfig)
+	}
+	if signingKeys.Default == nil || *signingKeys.Default != "release" {
+		t.Errorf("expected release to be the default key, got %v", signingKeys.Default)
+	}
+
+	// keys of notation or of another plugin are never overwritten
+	for _, name := range []string{"local", "remote"} {
+		err := registerNotationKey(ctx, "release", name, pluginConfig, false)
+		if err == nil || !strings.Contains(err.Error(), "does not use the hc-vault plugin") {
+			t.Errorf("expected %s to be refused, got %v", name, err)
+		}
+	}
+	// nor is a key that cannot be described
+	if err := registerNotationKey(ctx, "missing", "missing", pluginConfig, false); err == nil {
+		t.Error("expected a key without certificate chain to be refused")
+	}
+
+	signingKeys = reloadSigningKeys(t)
+	if len(signingKeys.Keys) != 3 {
+		t.Fatalf("expected 3 signing keys, got %+v", signingKeys.Keys)
+	}
+	if local := findKey(signingKeys, "local"); local == nil || local.X509KeyPair == nil || local.KeyPath != keyPath {
+		t.Errorf("expected local to be left alone, got %+v", local)
+	}
+	if remote := findKey(signingKeys, "remote"); remote == nil || remote.ExternalKey == nil || remote.PluginName != "other" {
+		t.Errorf("expected remote to be left alone, got %+v", remote)
+	}
+}
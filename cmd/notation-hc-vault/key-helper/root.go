@@ -1,25 +1,85 @@
 package key_helper
 
 import (
+	"context"
 	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/hashicorp/vault-client-go"
 	"github.com/spf13/cobra"
 	"os"
 )
 
+var configPath, profileName string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "path to the plugin config file (default $XDG_CONFIG_HOME/notation-hc-vault/config.json)")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", os.Getenv(keyvault.EnvProfile), "name of the Vault profile in the config file")
+	rootCmd.AddCommand(keyCmd, certCmd, trustCmd, authCmd, devCmd)
+}
+
 var rootCmd = &cobra.Command{
-	Use:   "key-helper",
-	Short: "key-helper - a simple CLI to import key and certificates to HashiCorp Vault",
-	Long: `key-helper - a simple CLI to import key and certificates to HashiCorp Vault
-   
-import key to Vault Transit secrets engine and certificates to Vault KV secrets engine`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("ah")
-	},
+	Use:   "notation-hc-vault",
+	Short: "HashiCorp Vault plugin for Notation",
+	Long: `HashiCorp Vault plugin for Notation
+
+Signs with keys held by the Vault Transit secrets engine, using certificate
+chains stored in the Vault KV secrets engine. notation invokes the plugin
+protocol commands (get-plugin-metadata, describe-key, generate-signature)
+directly; the commands below manage the keys and certificates.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "manage signing keys in the Vault Transit secrets engine",
+}
+
+var certCmd = &cobra.Command{
+	Use:   "cert",
+	Short: "manage certificate chains in the Vault KV secrets engine",
+}
+
+var trustCmd = &cobra.Command{
+	Use:   "trust",
+	Short: "manage notation trust stores and trust policies for verifiers",
 }
 
-func Execute() {
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "inspect the Vault authentication of the selected profile",
+}
+
+var devCmd = &cobra.Command{
+	Use:   "dev",
+	Short: "set up a development environment against a dev Vault server",
+}
+
+// Execute runs the management CLI with the process arguments and returns
+// the exit code.
+func Execute() int {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "Whoops. There was an error while executing your CLI '%s'", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// getProfile resolves the Vault profile selected by the --config and
+// --profile flags, the same way the plugin does.
+func getProfile() (*keyvault.Profile, error) {
+	return keyvault.LoadProfileFrom(configPath, profileName)
+}
+
+// getClient returns the selected profile and a client logged in to it.
+func getClient(ctx context.Context) (*keyvault.Profile, *vault.Client, error) {
+	profile, err := getProfile()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := keyvault.NewClient(ctx, profile)
+	if err != nil {
+		return nil, nil, err
 	}
+	return profile, client, nil
 }
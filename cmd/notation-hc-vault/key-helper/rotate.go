@@ -0,0 +1,117 @@
+package key_helper
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/crypto"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func init() {
+	keyCmd.AddCommand(rotateKeyCmd)
+	rotateKeyCmd.Flags().String("subject", "", "subject of the new certificate (default the subject of the current certificate)")
+	rotateKeyCmd.Flags().String("pki_mount", "pki", "mount of the Vault PKI secrets engine issuing the new certificate")
+	rotateKeyCmd.Flags().String("pki_role", "", "Vault PKI role issuing the new certificate")
+	rotateKeyCmd.Flags().String("csr_out", "", "path to write the CSR of the new key version for an external CA")
+	rotateKeyCmd.MarkFlagsMutuallyExclusive("pki_role", "csr_out")
+}
+
+var rotateKeyCmd = &cobra.Command{
+	Use:   "rotate <key>",
+	Short: "rotate a transit key and re-issue its certificate",
+	Long: `rotate a transit key and re-issue its certificate
+
+Adds a new version to the transit key and creates a CSR for it. With
+--pki_role, the CSR is signed by the Vault PKI secrets engine and the issued
+chain is stored right away. With --csr_out, the CSR is written for an
+external CA and the issued chain is stored later with "cert attach".
+
+Signing keeps using the key version paired with the current certificate
+until the chain of the new version is stored.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		keyName := args[0]
+		subjectFlag, _ := cmd.Flags().GetString("subject")
+		pkiMount, _ := cmd.Flags().GetString("pki_mount")
+		pkiRole, _ := cmd.Flags().GetString("pki_role")
+		csrOut, _ := cmd.Flags().GetString("csr_out")
+		if pkiRole == "" && csrOut == "" {
+			return errors.New("one of --pki_role or --csr_out is required")
+		}
+		profile, vaultClient, err := getClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		// imported keys only get new versions by import, refuse them before
+		// any change
+		key, err := keyvault.ReadTransitKey(ctx, vaultClient, profile, keyName)
+		if err != nil {
+			return err
+		}
+		if err := key.CheckRotatable(); err != nil {
+			return err
+		}
+		chain, err := keyvault.ReadCertificateChain(ctx, vaultClient, profile, keyName)
+		if err != nil {
+			return fmt.Errorf("failed to read the current certificate chain, %v", err)
+		}
+		var subject pkix.Name
+		if subjectFlag != "" {
+			if subject, err = crypto.ParseSubject(subjectFlag); err != nil {
+				return err
+			}
+		} else {
+			subject = chain.Certificates[0].Subject
+		}
+		if chain.KeyVersion == 0 {
+			// pin signing to the current version before transit moves on
+			if err := keyvault.PairCertificateChain(ctx, vaultClient, profile, keyName); err != nil {
+				return err
+			}
+			if chain, err = keyvault.ReadCertificateChain(ctx, vaultClient, profile, keyName); err != nil {
+				return err
+			}
+		}
+
+		version, err := keyvault.RotateTransitKey(ctx, vaultClient, profile, keyName)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Successfully rotated %s/keys/%s to version %d\n", profile.TransitMount, keyName, version)
+		signer, err := keyvault.NewTransitSigner(ctx, vaultClient, profile, keyName, version)
+		if err != nil {
+			return err
+		}
+		csr, err := crypto.CreateCertificateRequest(signer, subject)
+		if err != nil {
+			return fmt.Errorf("failed to create CSR, %v", err)
+		}
+
+		if pkiRole != "" {
+			certs, err := keyvault.IssueCertificate(ctx, vaultClient, pkiMount, pkiRole, csr, subject.CommonName)
+			if err != nil {
+				return err
+			}
+			if _, err := keyvault.AttachCertificateChain(ctx, vaultClient, profile, keyName, certs, version); err != nil {
+				return err
+			}
+			fmt.Printf("Successfully stored the certificate issued by %s/sign/%s, signing now uses version %d\n", pkiMount, pkiRole, version)
+			return nil
+		}
+
+		if err := os.WriteFile(csrOut, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Successfully wrote CSR to %s\n", csrOut)
+		fmt.Printf("Signing keeps using version %d until the issued chain is attached with\n", chain.KeyVersion)
+		fmt.Printf("  notation-hc-vault cert attach %s --cert_path certificate_chain.pem\n", keyName)
+		return nil
+	},
+}
@@ -0,0 +1,185 @@
+package key_helper
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/OliverShang/notation-hc-vault/internal/trust"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func init() {
+	trustCmd.AddCommand(exportTrustCmd, syncTrustCmd)
+	exportTrustCmd.Flags().String("store_name", "", "name of the CA trust store (default the key ID)")
+	exportTrustCmd.Flags().Bool("intermediates", false, "add the intermediate certificates to the trust store as well")
+	exportTrustCmd.Flags().String("output_dir", "", "notation configuration directory to write the trust store in (default $NOTATION_CONFIG or notation's user config directory)")
+	exportTrustCmd.Flags().String("policy_out", "", "file to write the trust policy to (default stdout)")
+	exportTrustCmd.Flags().String("policy_name", "", "name of the trust policy (default the trust store name)")
+	exportTrustCmd.Flags().StringSlice("registry_scopes", []string{"*"}, "registry scopes of the trust policy")
+	syncTrustCmd.Flags().StringSlice("pki_mounts", []string{"pki"}, "PKI secrets engine mounts whose issuers are trusted")
+	syncTrustCmd.Flags().String("store_name", "vault-pki", "name of the CA trust store")
+	syncTrustCmd.Flags().Bool("roots_only", false, "only trust the self-signed root issuers")
+	syncTrustCmd.Flags().String("output_dir", "", "notation configuration directory of the trust store (default $NOTATION_CONFIG or notation's user config directory)")
+	syncTrustCmd.Flags().Bool("dry_run", false, "report the changes without making them")
+}
+
+var exportTrustCmd = &cobra.Command{
+	Use:   "export <key id>",
+	Short: "write the trust store and trust policy verifying the signatures of a key",
+	Long: `write the trust store and trust policy verifying the signatures of a key
+
+Reads the certificate chain of the key, a key name or alias, and writes its
+root certificate to the notation trust store truststore/x509/ca/<store name>.
+A trust policy document trusting that store, and only the subject of the
+leaf certificate, is printed for merging into trustpolicy.json.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		keyID := args[0]
+		storeName, _ := cmd.Flags().GetString("store_name")
+		intermediates, _ := cmd.Flags().GetBool("intermediates")
+		outputDir, _ := cmd.Flags().GetString("output_dir")
+		policyOut, _ := cmd.Flags().GetString("policy_out")
+		policyName, _ := cmd.Flags().GetString("policy_name")
+		registryScopes, _ := cmd.Flags().GetStringSlice("registry_scopes")
+		if storeName == "" {
+			storeName = keyID
+		}
+		if policyName == "" {
+			policyName = storeName
+		}
+		if outputDir == "" {
+			outputDir = notationConfigDir()
+		}
+		storeDir, err := trust.StoreDir(outputDir, trust.StoreTypeCA, storeName)
+		if err != nil {
+			return err
+		}
+
+		config, err := keyvault.ResolveConfig(configPath)
+		if err != nil {
+			return err
+		}
+		vaultClient, err := keyvault.NewVaultClient(ctx, config, profileName, keyID)
+		if err != nil {
+			return err
+		}
+		certs, err := vaultClient.GetCertificateChain(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := trust.Root(certs); err != nil {
+			return fmt.Errorf("key %s: %v", keyID, err)
+		}
+		// the root is last, preceded by the intermediates
+		trusted := certs[len(certs)-1:]
+		if intermediates && len(certs) > 1 {
+			trusted = certs[1:]
+		}
+		written, err := trust.WriteCertificates(storeDir, trusted)
+		if err != nil {
+			return fmt.Errorf("failed to write trust store, %v", err)
+		}
+		for _, path := range written {
+			fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+		}
+		if len(written) == 0 {
+			fmt.Fprintf(os.Stderr, "Trust store %s is up to date\n", storeDir)
+		}
+
+		policy, err := trust.Policy(policyName, storeName, registryScopes, certs[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: notation will reject this trust policy, %v\n", err)
+		}
+		out := os.Stdout
+		if policyOut != "" {
+			if out, err = os.OpenFile(policyOut, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err != nil {
+				return err
+			}
+			defer out.Close()
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "    ")
+		return encoder.Encode(policy)
+	},
+}
+
+var syncTrustCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "make a notation trust store hold the issuers of Vault PKI mounts",
+	Long: `make a notation trust store hold the issuers of Vault PKI mounts
+
+Lists the issuers of the PKI mounts and reconciles the CA trust store
+truststore/x509/ca/<store name> with their certificates: new issuers are
+added and the certificates of deleted or revoked issuers are removed. Each
+change is reported. Files in the trust store not written by this command are
+left alone, and the trust store is never emptied, so that the command can run
+unattended, e.g. as a cron job on verifier hosts.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		mounts, _ := cmd.Flags().GetStringSlice("pki_mounts")
+		storeName, _ := cmd.Flags().GetString("store_name")
+		rootsOnly, _ := cmd.Flags().GetBool("roots_only")
+		outputDir, _ := cmd.Flags().GetString("output_dir")
+		dryRun, _ := cmd.Flags().GetBool("dry_run")
+		if outputDir == "" {
+			outputDir = notationConfigDir()
+		}
+		storeDir, err := trust.StoreDir(outputDir, trust.StoreTypeCA, storeName)
+		if err != nil {
+			return err
+		}
+		_, vaultClient, err := getClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var certs []*x509.Certificate
+		sources := make(map[string]string)
+		for _, mount := range mounts {
+			issuers, err := keyvault.ListPKIIssuers(ctx, vaultClient, mount)
+			if err != nil {
+				return err
+			}
+			for _, issuer := range issuers {
+				source := fmt.Sprintf("%s/issuer/%s", issuer.Mount, issuer.ID)
+				if issuer.Name != "" {
+					source = fmt.Sprintf("%s (%s)", source, issuer.Name)
+				}
+				if issuer.Revoked {
+					fmt.Printf("skipped revoked issuer %s\n", source)
+					continue
+				}
+				if _, err := trust.Root([]*x509.Certificate{issuer.Certificate}); err != nil && rootsOnly {
+					continue
+				}
+				certs = append(certs, issuer.Certificate)
+				sources[trust.CertificateFileName(issuer.Certificate)] = fmt.Sprintf("%s, %s", issuer.Certificate.Subject, source)
+			}
+		}
+
+		result, err := trust.Sync(storeDir, certs, dryRun)
+		if err != nil {
+			return fmt.Errorf("failed to synchronize trust store %s, %v", storeDir, err)
+		}
+		prefix := ""
+		if dryRun {
+			prefix = "would be "
+		}
+		for _, name := range result.Added {
+			fmt.Printf("%sadded %s: %s\n", prefix, name, sources[name])
+		}
+		for _, name := range result.Removed {
+			fmt.Printf("%sremoved %s\n", prefix, name)
+		}
+		for _, name := range result.Unmanaged {
+			fmt.Fprintf(os.Stderr, "Warning: %s is not managed by trust sync, left alone\n", name)
+		}
+		fmt.Printf("trust store %s: %d added, %d removed, %d unchanged\n", storeDir, len(result.Added), len(result.Removed), len(result.Unchanged))
+		return nil
+	},
+}
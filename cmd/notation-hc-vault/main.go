@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	key_helper "github.com/OliverShang/notation-hc-vault/cmd/notation-hc-vault/key-helper"
 	"os"
 
@@ -11,25 +10,22 @@ import (
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		help()
-		return
+	if len(os.Args) < 2 || !isProtocolCommand(os.Args[1]) {
+		// anything but a plugin protocol command is for the management CLI
+		os.Exit(key_helper.Execute())
 	}
 	ctx := context.Background()
 	var err error
 	var resp any
 	switch proto.Command(os.Args[1]) {
 	case proto.CommandGetMetadata:
-		resp = runGetMetadata()
+		resp = runGetMetadata(os.Stdin)
 	case proto.CommandDescribeKey:
 		resp, err = runDescribeKey(ctx, os.Stdin)
 	case proto.CommandGenerateSignature:
 		resp, err = runSign(ctx, os.Stdin)
-	default:
-		if os.Args[1] == "import" {
-			key_helper.Execute()
-		}
-		err = fmt.Errorf("invalid command: %s", os.Args[1])
+	case proto.CommandGenerateEnvelope:
+		resp, err = runGenerateEnvelope(ctx, os.Stdin)
 	}
 
 	// output the response
@@ -47,6 +43,10 @@ func main() {
 	}
 }
 
-func help() {
-	fmt.Print("Help not implemented yet.")
+func isProtocolCommand(arg string) bool {
+	switch proto.Command(arg) {
+	case proto.CommandGetMetadata, proto.CommandDescribeKey, proto.CommandGenerateSignature, proto.CommandGenerateEnvelope:
+		return true
+	}
+	return false
 }
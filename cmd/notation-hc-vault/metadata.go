@@ -1,17 +1,45 @@
 package main
 
 import (
+	"encoding/json"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
 	"github.com/OliverShang/notation-hc-vault/internal/version"
 	"github.com/notaryproject/notation-go/plugin/proto"
+	"io"
+	"os"
 )
 
-func runGetMetadata() *proto.GetMetadataResponse {
+func runGetMetadata(input io.Reader) *proto.GetMetadataResponse {
 	return &proto.GetMetadataResponse{
 		Name:                      "hc-vault",
 		Description:               "Sign artifacts with keys in HashiCorp Vault",
 		Version:                   version.GetVersion(),
 		URL:                       "https://github.com/OliverShang/notation-hc-vault",
 		SupportedContractVersions: []string{proto.ContractVersion},
-		Capabilities:              []proto.Capability{proto.CapabilitySignatureGenerator},
+		Capabilities:              []proto.Capability{capability(input)},
 	}
 }
+
+// capability returns the envelope generator capability if a profile
+// timestamps signatures, which notation can only do with the envelopes the
+// plugin generates, and the raw signature generator otherwise. Every profile
+// is considered, as the key ID is not known yet and may be an alias to any
+// of them.
+func capability(input io.Reader) proto.Capability {
+	// the request is optional, a missing or malformed one selects the
+	// default config file, and a terminal is not waited on
+	var req proto.GetMetadataRequest
+	if f, ok := input.(*os.File); !ok || !isTerminal(f) {
+		json.NewDecoder(input).Decode(&req)
+	}
+	config, err := keyvault.ResolveConfig(req.PluginConfig[keyvault.PluginConfigFile])
+	if err != nil || !config.Timestamps() {
+		return proto.CapabilitySignatureGenerator
+	}
+	return proto.CapabilityEnvelopeGenerator
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -1,32 +1,112 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
+	"errors"
+	"fmt"
+	"go.mozilla.org/pkcs7"
+	"sort"
+	"strings"
 )
 
-// parseCertificates parses certificates from either PEM or DER data
-// returns an empty list if no certificates are found
+// ParseCertificates parses certificates, in the order they appear, from
+// either:
+//   - PEM data, whose CERTIFICATE and PKCS7 blocks are read while other
+//     blocks, e.g. private keys, and the text around them are skipped
+//   - DER certificates, concatenated
+//   - a DER PKCS #7 certificate bundle (.p7b)
+//   - base64 encoded DER certificates or PKCS #7 bundle
+//
+// An error is returned if data holds no certificates.
 func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("no certificate data")
+	}
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		return parsePEMCertificates(data)
+	}
+	certs, err := parseDERCertificates(data)
+	if err == nil {
+		return certs, nil
+	}
+	der, decodeErr := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(data)), ""))
+	if decodeErr != nil {
+		return nil, fmt.Errorf("data is neither PEM, DER, PKCS #7 nor base64 encoded DER, %v", err)
+	}
+	if certs, err = parseDERCertificates(der); err != nil {
+		return nil, fmt.Errorf("failed to parse base64 encoded data, %v", err)
+	}
+	return certs, nil
+}
+
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
 	var certs []*x509.Certificate
-	block, rest := pem.Decode(data)
-	if block == nil {
-		// data may be in DER format
-		derCerts, err := x509.ParseCertificates(data)
-		if err != nil {
-			return nil, err
+	skipped := make(map[string]bool)
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
 		}
-		certs = append(certs, derCerts...)
-	} else {
-		// data is in PEM format
-		for block != nil {
+		data = rest
+		switch block.Type {
+		case "CERTIFICATE":
 			cert, err := x509.ParseCertificate(block.Bytes)
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("certificate %d: %v", len(certs), err)
 			}
 			certs = append(certs, cert)
-			block, rest = pem.Decode(rest)
+		case "PKCS7":
+			p7, err := ParsePKCS7(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("PKCS #7 block: %v", err)
+			}
+			certs = append(certs, p7.Certificates...)
+		default:
+			skipped[block.Type] = true
+		}
+	}
+	if len(certs) == 0 {
+		if len(skipped) == 0 {
+			return nil, errors.New("no valid PEM block found")
+		}
+		types := make([]string, 0, len(skipped))
+		for t := range skipped {
+			types = append(types, t)
 		}
+		sort.Strings(types)
+		return nil, fmt.Errorf("no certificates found, only %s PEM blocks", strings.Join(types, ", "))
 	}
 	return certs, nil
 }
+
+func parseDERCertificates(der []byte) ([]*x509.Certificate, error) {
+	certs, err := x509.ParseCertificates(der)
+	if err == nil {
+		if len(certs) == 0 {
+			return nil, errors.New("no certificates found")
+		}
+		return certs, nil
+	}
+	if p7, p7Err := ParsePKCS7(der); p7Err == nil {
+		if len(p7.Certificates) == 0 {
+			return nil, errors.New("the PKCS #7 bundle holds no certificates")
+		}
+		return p7.Certificates, nil
+	}
+	return nil, err
+}
+
+// ParsePKCS7 is pkcs7.Parse reporting malformed input as an error, as its
+// BER decoder panics on some truncated lengths.
+func ParsePKCS7(data []byte) (p7 *pkcs7.PKCS7, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p7, err = nil, fmt.Errorf("malformed PKCS #7 data, %v", r)
+		}
+	}()
+	return pkcs7.Parse(data)
+}
@@ -0,0 +1,149 @@
+package crypto
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/notaryproject/notation-core-go/testhelper"
+	"strings"
+	"testing"
+)
+
+func certificateCorpus(t testing.TB) map[string]struct {
+	data   []byte
+	want   []*x509.Certificate
+	errMsg string
+} {
+	leaf, root := testhelper.GetRSALeafCertificate().Cert, testhelper.GetRSARootCertificate().Cert
+	chain := []*x509.Certificate{leaf, root}
+	pemOf := func(blockType string, der []byte) string {
+		return string(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}))
+	}
+	p7b, err := EncodePKCS7(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der := append(append([]byte{}, leaf.Raw...), root.Raw...)
+	wrapped := base64.StdEncoding.EncodeToString(leaf.Raw)
+	for i := 64; i < len(wrapped); i += 65 {
+		wrapped = wrapped[:i] + "\n" + wrapped[i:]
+	}
+
+	return map[string]struct {
+		data   []byte
+		want   []*x509.Certificate
+		errMsg string
+	}{
+		"PEM certificate": {
+			data: []byte(pemOf("CERTIFICATE", leaf.Raw)),
+			want: chain[:1],
+		},
+		"PEM chain": {
+			data: []byte(pemOf("CERTIFICATE", leaf.Raw) + pemOf("CERTIFICATE", root.Raw)),
+			want: chain,
+		},
+		"PEM chain with comments and a private key": {
+			data: []byte("# release chain\n" + pemOf("CERTIFICATE", leaf.Raw) + "issuer: root\n" +
+				pemOf("PRIVATE KEY", []byte{1, 2, 3}) + pemOf("CERTIFICATE", root.Raw) + pemOf("COMMENT", []byte("end"))),
+			want: chain,
+		},
+		"PEM PKCS #7 bundle": {
+			data: []byte(pemOf("PKCS7", p7b)),
+			want: chain,
+		},
+		"DER certificate": {
+			data: leaf.Raw,
+			want: chain[:1],
+		},
+		"concatenated DER certificates": {
+			data: der,
+			want: chain,
+		},
+		"DER PKCS #7 bundle": {
+			data: p7b,
+			want: chain,
+		},
+		"base64 DER certificate": {
+			data: []byte(base64.StdEncoding.EncodeToString(leaf.Raw)),
+			want: chain[:1],
+		},
+		"wrapped base64 DER certificate": {
+			data: []byte(wrapped + "\n"),
+			want: chain[:1],
+		},
+		"base64 PKCS #7 bundle": {
+			data: []byte(base64.StdEncoding.EncodeToString(p7b)),
+			want: chain,
+		},
+		"empty": {
+			data:   nil,
+			errMsg: "no certificate data",
+		},
+		"whitespace": {
+			data:   []byte(" \n\t\n"),
+			errMsg: "no certificate data",
+		},
+		"only a private key": {
+			data:   []byte(pemOf("PRIVATE KEY", []byte{1, 2, 3}) + pemOf("EC PARAMETERS", []byte{4})),
+			errMsg: "only EC PARAMETERS, PRIVATE KEY PEM blocks",
+		},
+		"truncated PEM": {
+			data:   []byte(pemOf("CERTIFICATE", leaf.Raw)[:100]),
+			errMsg: "no valid PEM block",
+		},
+		"corrupted PEM certificate": {
+			data:   []byte(pemOf("CERTIFICATE", leaf.Raw[:len(leaf.Raw)/2])),
+			errMsg: "certificate 0",
+		},
+		"garbage": {
+			data:   []byte("not a certificate!"),
+			errMsg: "neither PEM, DER, PKCS #7 nor base64",
+		},
+		"base64 garbage": {
+			data:   []byte(base64.StdEncoding.EncodeToString([]byte("not a certificate"))),
+			errMsg: "failed to parse base64 encoded data",
+		},
+	}
+}
+
+func TestParseCertificates(t *testing.T) {
+	for name, tt := range certificateCorpus(t) {
+		t.Run(name, func(t *testing.T) {
+			certs, err := ParseCertificates(tt.data)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("expected error containing %q, got %v", tt.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(certs) != len(tt.want) {
+				t.Fatalf("expected %d certificates, got %d", len(tt.want), len(certs))
+			}
+			for i := range certs {
+				if !certs[i].Equal(tt.want[i]) {
+					t.Errorf("certificate %d: expected %q, got %q", i, tt.want[i].Subject, certs[i].Subject)
+				}
+			}
+		})
+	}
+}
+
+func FuzzParseCertificates(f *testing.F) {
+	for _, tt := range certificateCorpus(f) {
+		f.Add(tt.data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		certs, err := ParseCertificates(data)
+		if err == nil && len(certs) == 0 {
+			t.Error("expected an error when no certificates are returned")
+		}
+		for i, cert := range certs {
+			if cert == nil {
+				t.Fatalf("certificate %d is nil", i)
+			}
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// ParseSubject parses a distinguished name such as
+// "CN=release,O=Example,C=US" into a pkix.Name.
+func ParseSubject(s string) (pkix.Name, error) {
+	var name pkix.Name
+	for _, rdn := range strings.Split(s, ",") {
+		rdn = strings.TrimSpace(rdn)
+		if rdn == "" {
+			continue
+		}
+		attr, value, ok := strings.Cut(rdn, "=")
+		if !ok || strings.TrimSpace(value) == "" {
+			return pkix.Name{}, fmt.Errorf("malformed subject attribute %q", rdn)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToUpper(strings.TrimSpace(attr)) {
+		case "CN":
+			name.CommonName = value
+		case "O":
+			name.Organization = append(name.Organization, value)
+		case "OU":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, value)
+		case "C":
+			name.Country = append(name.Country, value)
+		case "ST":
+			name.Province = append(name.Province, value)
+		case "L":
+			name.Locality = append(name.Locality, value)
+		default:
+			return pkix.Name{}, fmt.Errorf("unsupported subject attribute %q, must be one of CN, O, OU, C, ST, L", attr)
+		}
+	}
+	if name.CommonName == "" {
+		return pkix.Name{}, fmt.Errorf("subject %q has no CN", s)
+	}
+	return name, nil
+}
+
+// CreateCertificateRequest returns a DER encoded CSR for a code signing
+// certificate, signed by signer.
+func CreateCertificateRequest(signer crypto.Signer, subject pkix.Name) ([]byte, error) {
+	return x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: subject,
+	}, signer)
+}
+
+// CreateSelfSignedCertificate returns a self-signed code signing certificate
+// meeting the Notary Project requirements for signing certificates, for
+// development and testing.
+func CreateSelfSignedCertificate(signer crypto.Signer, subject pkix.Name, validity time.Duration) (*x509.Certificate, error) {
+	template, err := CodeSigningTemplate(subject, validity)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// CreateCertificateAuthority returns a new ECDSA P-384 key and a self-signed
+// root CA certificate for it, for development and testing.
+func CreateCertificateAuthority(subject pkix.Name, validity time.Duration) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template, err := CodeSigningTemplate(subject, validity)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	template.ExtKeyUsage = nil
+	template.IsCA = true
+	template.MaxPathLenZero = true
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// IssueCodeSigningCertificate returns a code signing certificate for pub
+// issued by the CA, meeting the Notary Project requirements for signing
+// certificates. Its validity is capped by the one of the CA.
+func IssueCodeSigningCertificate(pub crypto.PublicKey, subject pkix.Name, validity time.Duration, ca *x509.Certificate, caKey crypto.Signer) (*x509.Certificate, error) {
+	template, err := CodeSigningTemplate(subject, validity)
+	if err != nil {
+		return nil, err
+	}
+	if template.NotAfter.After(ca.NotAfter) {
+		template.NotAfter = ca.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, pub, caKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// CodeSigningTemplate returns a leaf certificate template with the key usage,
+// extended key usage and basic constraints notation requires.
+func CodeSigningTemplate(subject pkix.Name, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+	}, nil
+}
@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestParseSubject(t *testing.T) {
+	name, err := ParseSubject("CN=release, O=Example, OU=Build, C=US")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name.CommonName != "release" || name.Organization[0] != "Example" || name.OrganizationalUnit[0] != "Build" || name.Country[0] != "US" {
+		t.Errorf("unexpected subject %+v", name)
+	}
+	for _, s := range []string{"", "O=Example", "CN", "CN=release,E=me@example.com"} {
+		if _, err := ParseSubject(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestCreateSelfSignedCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := ParseSubject("CN=dev")
+	cert, err := CreateSelfSignedCertificate(key, subject, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.IsCA || cert.KeyUsage != x509.KeyUsageDigitalSignature || len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageCodeSigning {
+		t.Errorf("certificate does not meet the notation signing certificate requirements")
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		t.Error(err)
+	}
+
+	csr, err := CreateCertificateRequest(key, subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := x509.ParseCertificateRequest(csr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := request.CheckSignature(); err != nil || request.Subject.CommonName != "dev" {
+		t.Errorf("unexpected CSR %+v, %v", request.Subject, err)
+	}
+}
+
+func TestIssueCodeSigningCertificate(t *testing.T) {
+	caSubject, _ := ParseSubject("C=US,ST=WA,O=Dev,CN=Dev Root")
+	ca, caKey, err := CreateCertificateAuthority(caSubject, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ca.IsCA || ca.KeyUsage&x509.KeyUsageCertSign == 0 {
+		t.Errorf("expected a CA certificate")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := ParseSubject("C=US,ST=WA,O=Dev,CN=dev")
+	leaf, err := IssueCodeSigningCertificate(key.Public(), subject, 24*time.Hour, ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.CheckSignatureFrom(ca); err != nil {
+		t.Error(err)
+	}
+	if leaf.NotAfter.After(ca.NotAfter) {
+		t.Errorf("expected the leaf to expire with the CA, got %v after %v", leaf.NotAfter, ca.NotAfter)
+	}
+	if err := ValidateSigningCertificates(key.Public(), []*x509.Certificate{leaf, ca}, time.Now()); err != nil {
+		t.Errorf("expected a valid notation signing chain, got %v", err)
+	}
+}
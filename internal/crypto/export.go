@@ -0,0 +1,94 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go.mozilla.org/pkcs7"
+	"math/big"
+)
+
+// EncodePKCS7 returns the certificates as a degenerate PKCS #7 SignedData
+// structure without signers, the DER form of a .p7b file.
+func EncodePKCS7(certs []*x509.Certificate) ([]byte, error) {
+	var raw []byte
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw...)
+	}
+	return pkcs7.DegenerateCertificate(raw)
+}
+
+// JSONWebKey is the JSON Web Key (RFC 7517) of an RSA or ECDSA public key.
+type JSONWebKey struct {
+	KeyType string `json:"kty"`
+	// KeyID is the RFC 7638 thumbprint of the key.
+	KeyID string `json:"kid"`
+	Use   string `json:"use"`
+	N     string `json:"n,omitempty"`
+	E     string `json:"e,omitempty"`
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+	// X509Chain holds the certificate chain of the key, leaf first.
+	X509Chain []string `json:"x5c,omitempty"`
+	// X509Thumbprint is the SHA-256 thumbprint of the leaf certificate.
+	X509Thumbprint string `json:"x5t#S256,omitempty"`
+}
+
+// NewJSONWebKey returns the signing JWK of the public key, along with the
+// certificate chain of the key, leaf first, if any.
+func NewJSONWebKey(pub crypto.PublicKey, certs []*x509.Certificate) (*JSONWebKey, error) {
+	encode := base64.RawURLEncoding.EncodeToString
+	jwk := &JSONWebKey{Use: "sig"}
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encode(key.N.Bytes())
+		jwk.E = encode(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk.KeyType = "EC"
+		jwk.Curve = key.Curve.Params().Name
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.X = encode(key.X.FillBytes(make([]byte, size)))
+		jwk.Y = encode(key.Y.FillBytes(make([]byte, size)))
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	// the thumbprint covers the required members in lexicographic order
+	var members interface{}
+	if jwk.KeyType == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	jwk.KeyID = encode(sum[:])
+
+	for _, cert := range certs {
+		jwk.X509Chain = append(jwk.X509Chain, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+	if len(certs) > 0 {
+		sum := sha256.Sum256(certs[0].Raw)
+		jwk.X509Thumbprint = encode(sum[:])
+	}
+	return jwk, nil
+}
@@ -0,0 +1,65 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"github.com/notaryproject/notation-core-go/testhelper"
+	"go.mozilla.org/pkcs7"
+	"math/big"
+	"testing"
+)
+
+func TestEncodePKCS7(t *testing.T) {
+	certs := []*x509.Certificate{testhelper.GetRSALeafCertificate().Cert, testhelper.GetRSARootCertificate().Cert}
+	der, err := EncodePKCS7(certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p7.Certificates) != 2 || !p7.Certificates[0].Equal(certs[0]) || !p7.Certificates[1].Equal(certs[1]) {
+		t.Errorf("unexpected certificates %v", p7.Certificates)
+	}
+}
+
+func TestNewJSONWebKey(t *testing.T) {
+	// the example key of RFC 7638, section 3.1
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := NewJSONWebKey(&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwk.KeyType != "RSA" || jwk.E != "AQAB" || jwk.KeyID != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("unexpected RSA JWK %+v", jwk)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := testhelper.GetECLeafCertificate().Cert
+	jwk, err = NewJSONWebKey(key.Public(), []*x509.Certificate{leaf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+	if jwk.KeyType != "EC" || jwk.Curve != "P-521" || len(x) != 66 || new(big.Int).SetBytes(x).Cmp(key.X) != 0 {
+		t.Errorf("unexpected EC JWK %+v", jwk)
+	}
+	if len(jwk.X509Chain) != 1 || jwk.X509Chain[0] != base64.StdEncoding.EncodeToString(leaf.Raw) || jwk.X509Thumbprint == "" {
+		t.Errorf("unexpected JWK certificate chain %+v", jwk)
+	}
+
+	if _, err := NewJSONWebKey([]byte("symmetric"), nil); err == nil {
+		t.Error("expected an unsupported key to be refused")
+	}
+}
@@ -0,0 +1,278 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"hash"
+	"math/big"
+	"os"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// PassphraseFunc returns the passphrase of an encrypted private key. It is
+// only called for encrypted keys.
+type PassphraseFunc func() ([]byte, error)
+
+// ReadPrivateKeyFile reads a private key from a PEM file holding a PKCS #1,
+// SEC 1 or PKCS #8 key, encrypted or not, from a DER file holding an
+// unencrypted key of one of these formats, or from a PKCS #12 bundle. The
+// certificates of a PKCS #12 bundle are returned as well, leaf first.
+func ReadPrivateKeyFile(path string, passphrase PassphraseFunc) (crypto.PrivateKey, []*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer Zeroize(data)
+	return ParsePrivateKey(data, passphrase)
+}
+
+// ParsePrivateKey parses a private key as ReadPrivateKeyFile does.
+func ParsePrivateKey(data []byte, passphrase PassphraseFunc) (crypto.PrivateKey, []*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		// not PEM, a DER key is tried before asking for the passphrase of a
+		// PKCS #12 bundle
+		if key, err := parseUnencryptedDER(data); err == nil {
+			return key, nil, nil
+		}
+		return parsePKCS12(data, passphrase)
+	}
+	defer Zeroize(block.Bytes)
+
+	switch {
+	case block.Type == "ENCRYPTED PRIVATE KEY":
+		password, err := passphrase()
+		if err != nil {
+			return nil, nil, err
+		}
+		defer Zeroize(password)
+		der, err := decryptPKCS8(block.Bytes, password)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer Zeroize(der)
+		key, err := x509.ParsePKCS8PrivateKey(der)
+		return key, nil, err
+	case x509.IsEncryptedPEMBlock(block):
+		// legacy OpenSSL encryption, as written by `openssl rsa -aes256`.
+		// DecryptPEMBlock is deprecated for its unauthenticated format, but
+		// remains the only upstream reader of these keys, which are only
+		// ever read here, never written
+		password, err := passphrase()
+		if err != nil {
+			return nil, nil, err
+		}
+		defer Zeroize(password)
+		der, err := x509.DecryptPEMBlock(block, password)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decrypt private key, %v", err)
+		}
+		defer Zeroize(der)
+		key, err := parsePrivateKeyDER(block.Type, der)
+		return key, nil, err
+	default:
+		key, err := parsePrivateKeyDER(block.Type, block.Bytes)
+		return key, nil, err
+	}
+}
+
+func parsePrivateKeyDER(blockType string, der []byte) (crypto.PrivateKey, error) {
+	switch blockType {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(der)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q, expected a private key", blockType)
+	}
+}
+
+// parseUnencryptedDER parses a DER PKCS #8, PKCS #1 or SEC 1 private key.
+func parseUnencryptedDER(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return x509.ParseECPrivateKey(der)
+}
+
+func parsePKCS12(data []byte, passphrase PassphraseFunc) (crypto.PrivateKey, []*x509.Certificate, error) {
+	password, err := passphrase()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer Zeroize(password)
+	key, leaf, caCerts, err := pkcs12.DecodeChain(data, string(password))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode PKCS #12 bundle, %v", err)
+	}
+	if leaf == nil {
+		return key, nil, nil
+	}
+	return key, append([]*x509.Certificate{leaf}, caCerts...), nil
+}
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+// encryptedPrivateKeyInfo is defined in RFC 5958, section 3.
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+// pbes2Params and pbkdf2Params are defined in RFC 8018, appendix A.
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 decrypts a PKCS #8 EncryptedPrivateKeyInfo protected with
+// PBES2, as written by `openssl pkcs8 -topk8` and `openssl genpkey -aes256`.
+func decryptPKCS8(der []byte, password []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("malformed encrypted private key, %v", err)
+	}
+	if !info.EncryptionAlgorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported private key encryption %v, only PBES2 is supported", info.EncryptionAlgorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.EncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("malformed PBES2 parameters, %v", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation function %v, only PBKDF2 is supported", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("malformed PBKDF2 parameters, %v", err)
+	}
+
+	var prf func() hash.Hash
+	switch algorithm := kdf.PRF.Algorithm; {
+	case len(algorithm) == 0, algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case algorithm.Equal(oidHMACWithSHA384):
+		prf = sha512.New384
+	case algorithm.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 pseudorandom function %v", algorithm)
+	}
+
+	var keyLength int
+	var newCipher func([]byte) (cipher.Block, error)
+	switch algorithm := params.EncryptionScheme.Algorithm; {
+	case algorithm.Equal(oidAES128CBC):
+		keyLength, newCipher = 16, aes.NewCipher
+	case algorithm.Equal(oidAES192CBC):
+		keyLength, newCipher = 24, aes.NewCipher
+	case algorithm.Equal(oidAES256CBC):
+		keyLength, newCipher = 32, aes.NewCipher
+	case algorithm.Equal(oidDESEDE3CBC):
+		keyLength, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, fmt.Errorf("unsupported private key cipher %v", algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("malformed cipher parameters, %v", err)
+	}
+
+	if kdf.IterationCount <= 0 || (kdf.KeyLength != 0 && kdf.KeyLength != keyLength) {
+		return nil, errors.New("malformed PBKDF2 parameters")
+	}
+	key := pbkdf2.Key(password, kdf.Salt, kdf.IterationCount, keyLength, prf)
+	defer Zeroize(key)
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() || len(info.EncryptedData) == 0 || len(info.EncryptedData)%block.BlockSize() != 0 {
+		return nil, errors.New("malformed encrypted private key")
+	}
+	plaintext := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, info.EncryptedData)
+
+	// a wrong passphrase almost always shows up as invalid padding
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > block.BlockSize() || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		Zeroize(plaintext)
+		return nil, errors.New("failed to decrypt private key, wrong passphrase?")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// Zeroize overwrites b with zeros.
+func Zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// ZeroizePrivateKey overwrites the secret values of an RSA or ECDSA private
+// key, which must not be used afterwards.
+func ZeroizePrivateKey(key crypto.PrivateKey) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		zeroizeInt(k.D)
+		for _, prime := range k.Primes {
+			zeroizeInt(prime)
+		}
+		zeroizeInt(k.Precomputed.Dp)
+		zeroizeInt(k.Precomputed.Dq)
+		zeroizeInt(k.Precomputed.Qinv)
+	case *ecdsa.PrivateKey:
+		zeroizeInt(k.D)
+	}
+}
+
+func zeroizeInt(n *big.Int) {
+	if n == nil {
+		return
+	}
+	words := n.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	n.SetInt64(0)
+}
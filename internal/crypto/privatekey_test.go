@@ -0,0 +1,182 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func passphrase(s string) PassphraseFunc {
+	return func() ([]byte, error) {
+		return []byte(s), nil
+	}
+}
+
+func noPassphrase() ([]byte, error) {
+	return nil, errors.New("passphrase requested")
+}
+
+// The test keys were generated with OpenSSL 3, the passphrase is "notation".
+func TestReadPrivateKeyFile(t *testing.T) {
+	rsaKey, _, err := ReadPrivateKeyFile(filepath.Join("testdata", "rsa-aes256.p8.pem"), passphrase("notation"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rsaKey.(*rsa.PrivateKey); !ok {
+		t.Fatalf("expected an RSA key, got %T", rsaKey)
+	}
+	legacyKey, _, err := ReadPrivateKeyFile(filepath.Join("testdata", "rsa-legacy.pem"), passphrase("notation"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rsaKey.(*rsa.PrivateKey).Equal(legacyKey) {
+		t.Error("expected the PKCS #8 and legacy encrypted keys to be the same key")
+	}
+
+	ecKey, certs, err := ReadPrivateKeyFile(filepath.Join("testdata", "ec-des3.p8.pem"), passphrase("notation"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ecKey.(*ecdsa.PrivateKey); !ok || certs != nil {
+		t.Fatalf("expected an ECDSA key without certificates, got %T", ecKey)
+	}
+	for _, name := range []string{"ec-chain.p12", "ec-chain-legacy.p12"} {
+		key, certs, err := ReadPrivateKeyFile(filepath.Join("testdata", name), passphrase("notation"))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !ecKey.(*ecdsa.PrivateKey).Equal(key) {
+			t.Errorf("%s: expected the bundled key to be the PKCS #8 key", name)
+		}
+		if len(certs) != 2 || certs[0].Subject.CommonName != "Notation Test Leaf" || certs[1].Subject.CommonName != "Notation Test Root" {
+			t.Errorf("%s: expected the leaf and root certificates", name)
+		}
+	}
+}
+
+// The known-answer fixtures in testdata/kat encrypt the same P-256 key,
+// key.p8.pem, with OpenSSL 3 and the passphrase "notation":
+//
+//	openssl pkcs8 -topk8 -in key.p8.pem -v2 <cipher> -v2prf <prf> -out pbes2-<cipher>-<prf>.pem
+//	openssl ec -in key.sec1.pem -<cipher> -out legacy-<cipher>.pem
+//
+// (single DES needs `-provider legacy -provider default`).
+func TestDecryptKnownAnswers(t *testing.T) {
+	dir := filepath.Join("testdata", "kat")
+	readPEM := func(t *testing.T, name string) *pem.Block {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			t.Fatalf("%s: no PEM block", name)
+		}
+		return block
+	}
+	want := readPEM(t, "key.p8.pem").Bytes
+	wantKey, err := x509.ParsePKCS8PrivateKey(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cipher := range []string{"aes-128-cbc", "aes-192-cbc", "aes-256-cbc", "des-ede3-cbc"} {
+		for _, prf := range []string{"hmacWithSHA1", "hmacWithSHA256", "hmacWithSHA384", "hmacWithSHA512"} {
+			name := fmt.Sprintf("pbes2-%s-%s.pem", cipher, prf)
+			block := readPEM(t, name)
+			der, err := decryptPKCS8(block.Bytes, []byte("notation"))
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			if !bytes.Equal(der, want) {
+				t.Errorf("%s: decrypted key does not match key.p8.pem", name)
+			}
+			if _, err := decryptPKCS8(block.Bytes, []byte("wrong")); err == nil {
+				t.Errorf("%s: expected an error for a wrong passphrase", name)
+			}
+		}
+	}
+
+	for _, cipher := range []string{"des", "des3", "aes128", "aes192", "aes256"} {
+		name := fmt.Sprintf("legacy-%s.pem", cipher)
+		key, _, err := ReadPrivateKeyFile(filepath.Join(dir, name), passphrase("notation"))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !wantKey.(*ecdsa.PrivateKey).Equal(key) {
+			t.Errorf("%s: decrypted key does not match key.p8.pem", name)
+		}
+	}
+}
+
+func TestParsePrivateKeyDER(t *testing.T) {
+	rsaKey, _, err := ReadPrivateKeyFile(filepath.Join("testdata", "rsa-aes256.p8.pem"), passphrase("notation"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join("testdata", "kat")
+	ders := map[string][]byte{"PKCS #1": x509.MarshalPKCS1PrivateKey(rsaKey.(*rsa.PrivateKey))}
+	for name, file := range map[string]string{"PKCS #8": "key.p8.pem", "SEC 1": "key.sec1.pem"} {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			t.Fatalf("%s: no PEM block", file)
+		}
+		ders[name] = block.Bytes
+	}
+	// an unencrypted DER key never asks for a passphrase
+	for name, der := range ders {
+		key, certs, err := ParsePrivateKey(der, noPassphrase)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if key == nil || certs != nil {
+			t.Errorf("%s: expected a key without certificates, got %T", name, key)
+		}
+	}
+}
+
+func TestReadPrivateKeyFileErrors(t *testing.T) {
+	if _, _, err := ReadPrivateKeyFile(filepath.Join("testdata", "rsa-aes256.p8.pem"), passphrase("wrong")); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Errorf("expected a wrong passphrase error, got %v", err)
+	}
+	if _, _, err := ReadPrivateKeyFile(filepath.Join("testdata", "ec-chain.p12"), passphrase("wrong")); err == nil {
+		t.Error("expected an error for a wrong PKCS #12 password")
+	}
+	if _, _, err := ReadPrivateKeyFile(filepath.Join("testdata", "ec-des3.p8.pem"), noPassphrase); err == nil || err.Error() != "passphrase requested" {
+		t.Errorf("expected the passphrase error to be returned, got %v", err)
+	}
+}
+
+func TestZeroizePrivateKey(t *testing.T) {
+	key, _, err := ReadPrivateKeyFile(filepath.Join("testdata", "rsa-aes256.p8.pem"), passphrase("notation"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey := key.(*rsa.PrivateKey)
+	words := rsaKey.D.Bits()
+	ZeroizePrivateKey(rsaKey)
+	for _, word := range words {
+		if word != 0 {
+			t.Fatal("expected the private exponent to be overwritten")
+		}
+	}
+	if rsaKey.D.Sign() != 0 || rsaKey.Primes[0].Sign() != 0 {
+		t.Error("expected the private key values to be zero")
+	}
+}
@@ -62,7 +62,7 @@ func TestSign(t *testing.T) {
 	if err != nil {
 		panic(err)
 	}
-	vaultClient, err := keyvault.NewVaultClientFromKeyID("alpine")
+	vaultClient, err := keyvault.NewVaultClientFromKeyID(ctx, "alpine", nil)
 	if err != nil {
 		panic(err)
 	}
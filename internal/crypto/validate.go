@@ -0,0 +1,48 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	notationx509 "github.com/notaryproject/notation-core-go/x509"
+	"time"
+)
+
+// ValidateSigningCertificates checks that certs is a certificate chain, leaf
+// first, meeting the Notary Project certificate requirements at time now.
+// If pub is not nil, the leaf certificate must also be the one of that key.
+// All the problems found are reported.
+func ValidateSigningCertificates(pub crypto.PublicKey, certs []*x509.Certificate, now time.Time) error {
+	if len(certs) == 0 {
+		return errors.New("no certificates")
+	}
+	var errs []error
+	if pub != nil {
+		if signer, ok := pub.(crypto.Signer); ok {
+			pub = signer.Public()
+		}
+		key, ok := pub.(interface{ Equal(crypto.PublicKey) bool })
+		if !ok || !key.Equal(certs[0].PublicKey) {
+			errs = append(errs, fmt.Errorf("the private key does not match the leaf certificate %q", certs[0].Subject))
+		}
+	}
+	if err := notationx509.ValidateCodeSigningCertChain(certs, &now); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// CheckValidityPeriods checks that every certificate of certs is valid at
+// time now, reporting the first one that is expired or not yet valid.
+func CheckValidityPeriods(certs []*x509.Certificate, now time.Time) error {
+	for _, cert := range certs {
+		if now.Before(cert.NotBefore) {
+			return fmt.Errorf("certificate %q is not valid until %s", cert.Subject, cert.NotBefore.Format(time.RFC3339))
+		}
+		if now.After(cert.NotAfter) {
+			return fmt.Errorf("certificate %q expired on %s", cert.Subject, cert.NotAfter.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package crypto
+
+import (
+	"crypto/x509"
+	"github.com/notaryproject/notation-core-go/testhelper"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateSigningCertificates(t *testing.T) {
+	leaf, root := testhelper.GetRSALeafCertificate(), testhelper.GetRSARootCertificate()
+	other := testhelper.GetECLeafCertificate()
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		key    any
+		certs  []*x509.Certificate
+		errMsg []string
+	}{
+		{name: "valid", key: leaf.PrivateKey, certs: []*x509.Certificate{leaf.Cert, root.Cert}},
+		{name: "certificates only", certs: []*x509.Certificate{leaf.Cert, root.Cert}},
+		{
+			name:   "key mismatch",
+			key:    other.PrivateKey,
+			certs:  []*x509.Certificate{leaf.Cert, root.Cert},
+			errMsg: []string{"does not match the leaf certificate"},
+		},
+		{
+			name:   "root first",
+			key:    leaf.PrivateKey,
+			certs:  []*x509.Certificate{root.Cert, leaf.Cert},
+			errMsg: []string{"does not match the leaf certificate", "self-signed"},
+		},
+		{
+			name:   "CA as leaf",
+			key:    root.PrivateKey,
+			certs:  []*x509.Certificate{root.Cert},
+			errMsg: []string{"invalid self-signed certificate", "the ca field must be set to false"},
+		},
+		{name: "no certificates", key: leaf.PrivateKey, errMsg: []string{"no certificates"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSigningCertificates(tt.key, tt.certs, now)
+			if len(tt.errMsg) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, msg := range tt.errMsg {
+				if !strings.Contains(err.Error(), msg) {
+					t.Errorf("expected %q in %v", msg, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckValidityPeriods(t *testing.T) {
+	leaf, root := testhelper.GetRSALeafCertificate().Cert, testhelper.GetRSARootCertificate().Cert
+	certs := []*x509.Certificate{leaf, root}
+
+	if err := CheckValidityPeriods(certs, time.Now()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := CheckValidityPeriods(certs, leaf.NotAfter.Add(time.Second)); err == nil || !strings.Contains(err.Error(), "expired on") {
+		t.Errorf("expected an expired leaf, got %v", err)
+	}
+	if err := CheckValidityPeriods(certs, root.NotBefore.Add(-time.Second)); err == nil || !strings.Contains(err.Error(), "is not valid until") {
+		t.Errorf("expected a not yet valid certificate, got %v", err)
+	}
+}
@@ -0,0 +1,233 @@
+package keyvault
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultAIATimeout  = 10 * time.Second
+	defaultAIAMaxSize  = 64 * 1024
+	defaultAIAMaxDepth = 4
+	defaultAIACacheTTL = 24 * time.Hour
+)
+
+// ChainCompletionConfig configures the completion of partial certificate
+// chains by following the Authority Information Access caIssuers URLs of
+// their last certificate.
+type ChainCompletionConfig struct {
+	// Timeout is a Go duration bounding each caIssuers download, default 10s.
+	Timeout string `json:"timeout,omitempty"`
+	// MaxSize is the maximum size in bytes of a caIssuers response, default
+	// 64KiB.
+	MaxSize int64 `json:"maxSize,omitempty"`
+	// MaxDepth is the maximum number of certificates added to a chain,
+	// default 4.
+	MaxDepth int `json:"maxDepth,omitempty"`
+	// CacheTTL is a Go duration during which a downloaded issuer is reused
+	// without downloading it again, default 24h. 0 disables the cache.
+	CacheTTL string `json:"cacheTTL,omitempty"`
+	// WriteBack stores the completed chain in KV, so that the issuers are
+	// downloaded only once for all users of the key.
+	WriteBack bool `json:"writeBack,omitempty"`
+
+	timeout  time.Duration
+	cacheTTL time.Duration
+}
+
+func (c *ChainCompletionConfig) validate() error {
+	c.timeout = defaultAIATimeout
+	if c.Timeout != "" {
+		timeout, err := time.ParseDuration(c.Timeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %q", c.Timeout)
+		}
+		c.timeout = timeout
+	}
+	c.cacheTTL = defaultAIACacheTTL
+	if c.CacheTTL != "" {
+		ttl, err := time.ParseDuration(c.CacheTTL)
+		if err != nil || ttl < 0 {
+			return fmt.Errorf("invalid cacheTTL %q", c.CacheTTL)
+		}
+		c.cacheTTL = ttl
+	}
+	switch {
+	case c.MaxSize < 0:
+		return fmt.Errorf("invalid maxSize %d", c.MaxSize)
+	case c.MaxSize == 0:
+		c.MaxSize = defaultAIAMaxSize
+	}
+	switch {
+	case c.MaxDepth < 0:
+		return fmt.Errorf("invalid maxDepth %d", c.MaxDepth)
+	case c.MaxDepth == 0:
+		c.MaxDepth = defaultAIAMaxDepth
+	}
+	return nil
+}
+
+// issuerFetcher downloads issuer certificates from caIssuers URLs, caching
+// them on disk by URL.
+type issuerFetcher struct {
+	config   *ChainCompletionConfig
+	client   *http.Client
+	cacheDir string
+}
+
+func newIssuerFetcher(config *ChainCompletionConfig) *issuerFetcher {
+	f := &issuerFetcher{
+		config: config,
+		client: &http.Client{Timeout: config.timeout},
+	}
+	if config.cacheTTL > 0 {
+		if dir, err := CacheDir(); err == nil {
+			f.cacheDir = filepath.Join(dir, "issuers")
+		}
+	}
+	return f
+}
+
+// CompleteChain appends to certs, leaf first, the issuers found by following
+// the caIssuers URLs of the last certificate until a self-signed one is
+// reached. A chain whose last certificate has no caIssuers URL is returned
+// as is.
+func CompleteChain(ctx context.Context, config *ChainCompletionConfig, certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return certs, nil
+	}
+	f := newIssuerFetcher(config)
+	for added := 0; ; added++ {
+		last := certs[len(certs)-1]
+		if isSelfSigned(last) || len(last.IssuingCertificateURL) == 0 {
+			return certs, nil
+		}
+		if added == config.MaxDepth {
+			return nil, fmt.Errorf("certificate chain still incomplete after adding %d issuers", added)
+		}
+		issuer, err := f.issuer(ctx, last)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch the issuer of %q, %v", last.Subject, err)
+		}
+		certs = append(certs, issuer)
+	}
+}
+
+// issuer returns the certificate that signed cert, from the first of its
+// caIssuers URLs serving it.
+func (f *issuerFetcher) issuer(ctx context.Context, cert *x509.Certificate) (*x509.Certificate, error) {
+	var errs []error
+	for _, rawURL := range cert.IssuingCertificateURL {
+		candidates, err := f.fetch(ctx, rawURL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", rawURL, err))
+			continue
+		}
+		for _, candidate := range candidates {
+			if cert.CheckSignatureFrom(candidate) == nil {
+				return candidate, nil
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s: no certificate served is the issuer", rawURL))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// fetch returns the certificates served at a caIssuers URL, DER, PEM or a
+// PKCS #7 bundle, from the cache if they were downloaded within the TTL.
+func (f *issuerFetcher) fetch(ctx context.Context, rawURL string) ([]*x509.Certificate, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errors.New("unsupported URL, only http(s) is supported")
+	}
+	cachePath := f.cachePath(rawURL)
+	if cachePath != "" {
+		if info, err := os.Stat(cachePath); err == nil && time.Since(info.ModTime()) < f.config.cacheTTL {
+			if data, err := os.ReadFile(cachePath); err == nil {
+				if certs, err := ParseCertificates(data); err == nil {
+					return certs, nil
+				}
+			}
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	// read one byte past the limit to tell a response of exactly MaxSize
+	// bytes from a larger one
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.config.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > f.config.MaxSize {
+		return nil, fmt.Errorf("response larger than %d bytes", f.config.MaxSize)
+	}
+	certs, err := ParseCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+	if cachePath != "" {
+		// a failure to cache only costs a download
+		WriteFileAtomic(cachePath, data)
+	}
+	return certs, nil
+}
+
+func (f *issuerFetcher) cachePath(rawURL string) string {
+	if f.cacheDir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(rawURL))
+	return filepath.Join(f.cacheDir, hex.EncodeToString(sum[:]))
+}
+
+// isSelfSigned reports whether cert is a root, issued and signed by itself.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// completeCertificateChain completes the chain of the key as configured by
+// the profile and, with write-back enabled, stores the completed chain in
+// the KV secret it was read from.
+func (vw *VaultClientWrapper) completeCertificateChain(ctx context.Context, chain *CertificateChain, secret *KVSecret) (*CertificateChain, error) {
+	config := vw.profile.ChainCompletion
+	n := len(chain.Certificates)
+	certs, err := CompleteChain(ctx, config, chain.Certificates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete the certificate chain of %s, %v", vw.profile.KVLocation(vw.target.CertPath), err)
+	}
+	chain.Certificates = certs
+	if len(certs) == n || !config.WriteBack {
+		return chain, nil
+	}
+	version, err := StoreCertificateChain(ctx, vw.vaultClient, vw.profile, vw.target.CertPath, certs, chain.KeyVersion, nil, secret)
+	if err != nil {
+		// signing goes on with the completed chain, the issuers are
+		// downloaded again next time
+		fmt.Fprintf(os.Stderr, "warning: failed to store the completed certificate chain, %v\n", err)
+		return chain, nil
+	}
+	chain.Version = version
+	return chain, nil
+}
@@ -0,0 +1,230 @@
+package keyvault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// issueTestCertificate issues a certificate for a new key from the template,
+// self-signed if parent is nil.
+func issueTestCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// aiaServer serves the issuers of a root, intermediate, leaf hierarchy at
+// the caIssuers URLs of the intermediate and the leaf, counting downloads.
+type aiaServer struct {
+	*httptest.Server
+	root, intermediate, leaf *x509.Certificate
+
+	mu        sync.Mutex
+	downloads int
+}
+
+func newAIAServer(t *testing.T) *aiaServer {
+	t.Helper()
+	s := &aiaServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.downloads++
+		s.mu.Unlock()
+		switch r.URL.Path {
+		case "/root.cer":
+			w.Write(s.root.Raw)
+		case "/intermediate.p7c":
+			// PEM rather than the DER RFC 5280 expects, as some CAs serve
+			w.Write([]byte(encodePEM([]*x509.Certificate{s.intermediate})))
+		case "/large.cer":
+			w.Write(make([]byte, 2*defaultAIAMaxSize))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	var rootKey, intermediateKey *ecdsa.PrivateKey
+	s.root, rootKey = issueTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "AIA Root"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	s.intermediate, intermediateKey = issueTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "AIA Intermediate"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		IssuingCertificateURL: []string{s.URL + "/missing.cer", s.URL + "/root.cer"},
+	}, s.root, rootKey)
+	s.leaf, _ = issueTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "AIA Leaf"},
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		IssuingCertificateURL: []string{s.URL + "/intermediate.p7c"},
+	}, s.intermediate, intermediateKey)
+	return s
+}
+
+func (s *aiaServer) downloadCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.downloads
+}
+
+func TestCompleteChain(t *testing.T) {
+	t.Setenv(EnvCacheDir, t.TempDir())
+	server := newAIAServer(t)
+	config := &ChainCompletionConfig{}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	certs, err := CompleteChain(context.Background(), config, []*x509.Certificate{server.leaf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 3 || !certs[1].Equal(server.intermediate) || !certs[2].Equal(server.root) {
+		t.Fatalf("expected leaf, intermediate and root, got %d certificates", len(certs))
+	}
+	// the intermediate, then the missing URL and the root
+	if n := server.downloadCount(); n != 3 {
+		t.Fatalf("expected 3 downloads, got %d", n)
+	}
+
+	// issuers are served from the cache, the missing one is retried
+	if _, err := CompleteChain(context.Background(), config, []*x509.Certificate{server.leaf}); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.downloadCount(); n != 4 {
+		t.Fatalf("expected 4 downloads, got %d", n)
+	}
+
+	// a complete chain is left alone
+	certs, err = CompleteChain(context.Background(), config, []*x509.Certificate{server.leaf, server.intermediate, server.root})
+	if err != nil || len(certs) != 3 {
+		t.Fatalf("expected the complete chain back, got %d certificates, %v", len(certs), err)
+	}
+
+	config = &ChainCompletionConfig{MaxDepth: 1, CacheTTL: "0s"}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompleteChain(context.Background(), config, []*x509.Certificate{server.leaf}); err == nil || !strings.Contains(err.Error(), "still incomplete after adding 1 issuers") {
+		t.Fatalf("expected depth limit error, got %v", err)
+	}
+}
+
+func TestCompleteChainLimits(t *testing.T) {
+	server := newAIAServer(t)
+	config := &ChainCompletionConfig{CacheTTL: "0s"}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	f := newIssuerFetcher(config)
+	if _, err := f.fetch(context.Background(), server.URL+"/large.cer"); err == nil || !strings.Contains(err.Error(), "response larger than") {
+		t.Errorf("expected size limit error, got %v", err)
+	}
+	if _, err := f.fetch(context.Background(), "ldap://ldap.example.com/cn=CA"); err == nil || !strings.Contains(err.Error(), "unsupported URL") {
+		t.Errorf("expected unsupported URL error, got %v", err)
+	}
+
+	config = &ChainCompletionConfig{Timeout: "-1s"}
+	if err := config.validate(); err == nil {
+		t.Error("expected invalid timeout error")
+	}
+}
+
+func TestGetCertificateChainWriteBack(t *testing.T) {
+	t.Setenv(EnvCacheDir, t.TempDir())
+	server := newAIAServer(t)
+
+	var mu sync.Mutex
+	secret := map[string]any{"certificate": encodePEM([]*x509.Certificate{server.leaf}), "key_version": 2, "owner": "release"}
+	version := 1
+	var written map[string]any
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/v1/secret/data/signing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodPut || r.Method == http.MethodPost {
+			var body map[string]any
+			json.NewDecoder(r.Body).Decode(&body)
+			written = body
+			secret = body["data"].(map[string]any)
+			version++
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"version": version}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"data": secret, "metadata": map[string]any{"version": version}}})
+	}))
+	defer vault.Close()
+
+	profile := &Profile{
+		Name:            "dev",
+		Address:         vault.URL,
+		Auth:            &AuthConfig{Token: "root"},
+		ChainCache:      &ChainCacheConfig{Disabled: true},
+		ChainCompletion: &ChainCompletionConfig{WriteBack: true},
+	}
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(context.Background(), profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vw := &VaultClientWrapper{vaultClient: client, profile: profile, target: Target{Key: "signing", CertPath: "signing"}}
+
+	chain, err := vw.CertificateChain(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain.Certificates) != 3 || chain.KeyVersion != 2 || chain.Version != 2 {
+		t.Fatalf("unexpected chain of %d certificates paired with %d at version %d", len(chain.Certificates), chain.KeyVersion, chain.Version)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if written == nil {
+		t.Fatal("expected the completed chain to be written back")
+	}
+	if cas := written["options"].(map[string]any)["cas"]; cas != float64(1) {
+		t.Errorf("expected a check-and-set against version 1, got %v", cas)
+	}
+	if secret["owner"] != "release" || strings.Count(secret["certificate"].(string), "BEGIN CERTIFICATE") != 3 {
+		t.Errorf("unexpected secret written back %v", secret)
+	}
+}
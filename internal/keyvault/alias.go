@@ -0,0 +1,108 @@
+package keyvault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault-client-go"
+)
+
+// Alias maps a friendly key ID, such as release-signing, to a concrete
+// Vault location so keys can move without touching notation's configuration.
+type Alias struct {
+	// Profile overrides the selected profile, if set.
+	Profile string `json:"profile,omitempty"`
+	// Key is the transit key name.
+	Key string `json:"key"`
+	// Version pins the transit key version, 0 means the latest version.
+	Version int `json:"version,omitempty"`
+	// CertPath is the KV path of the certificate chain, defaults to Key.
+	CertPath string `json:"certPath,omitempty"`
+}
+
+func (a *Alias) validate(name string) error {
+	if name == "" {
+		return errors.New("alias name cannot be empty")
+	}
+	if a.Key == "" {
+		return fmt.Errorf("alias %q: key is required", name)
+	}
+	if a.Version < 0 {
+		return fmt.Errorf("alias %q: version must not be negative", name)
+	}
+	return nil
+}
+
+// Target is the concrete Vault location a key ID resolves to.
+type Target struct {
+	Alias    string `json:"alias,omitempty"`
+	Profile  string `json:"profile"`
+	Key      string `json:"key"`
+	Version  int    `json:"version,omitempty"`
+	CertPath string `json:"certPath"`
+}
+
+func (t Target) String() string {
+	version := "latest"
+	if t.Version > 0 {
+		version = fmt.Sprintf("v%d", t.Version)
+	}
+	return fmt.Sprintf("profile=%s key=%s version=%s cert=%s", t.Profile, t.Key, version, t.CertPath)
+}
+
+func (a *Alias) target(name string) Target {
+	certPath := a.CertPath
+	if certPath == "" {
+		certPath = a.Key
+	}
+	return Target{
+		Alias:    name,
+		Key:      a.Key,
+		Version:  a.Version,
+		CertPath: certPath,
+	}
+}
+
+// alias returns the alias defined in the config file, if any.
+func (c *Config) alias(name string) (*Alias, bool) {
+	if c == nil {
+		return nil, false
+	}
+	alias, ok := c.Aliases[name]
+	return alias, ok
+}
+
+// readKVAlias looks up name in the alias table stored at profile.AliasPath.
+// It returns nil if the table has no such entry, or does not exist yet.
+func readKVAlias(ctx context.Context, client *vault.Client, profile *Profile, name string) (*Alias, error) {
+	secret, err := ReadKVIfExists(ctx, client, profile, profile.AliasPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alias table, %v", err)
+	}
+	if secret == nil {
+		return nil, nil
+	}
+	value, ok := secret.Data[name]
+	if !ok {
+		return nil, nil
+	}
+
+	// entries are either JSON objects or JSON encoded strings
+	raw, ok := value.(string)
+	if !ok {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		raw = string(b)
+	}
+	var alias Alias
+	if err := json.Unmarshal([]byte(raw), &alias); err != nil {
+		return nil, fmt.Errorf("malformed alias %q in %s/%s, %v", name, profile.KVMount, profile.AliasPath, err)
+	}
+	if err := alias.validate(name); err != nil {
+		return nil, err
+	}
+	return &alias, nil
+}
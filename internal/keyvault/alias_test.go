@@ -0,0 +1,122 @@
+package keyvault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newFakeVault serves canned responses keyed by request path.
+func newFakeVault(t *testing.T, responses map[string]any) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": resp})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewVaultClientConfigAlias(t *testing.T) {
+	config := &Config{
+		Profiles: map[string]*Profile{
+			"dev":  {Address: "http://127.0.0.1:8200", Auth: &AuthConfig{Token: "root"}},
+			"prod": {Address: "https://vault.example.com", Auth: &AuthConfig{Token: "root"}, KVMount: "certs"},
+		},
+		DefaultProfile: "dev",
+		Aliases: map[string]*Alias{
+			"release-signing": {Profile: "prod", Key: "release-2023", Version: 3, CertPath: "release/chain"},
+		},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	vw, err := NewVaultClient(context.Background(), config, "", "release-signing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Target{Alias: "release-signing", Profile: "prod", Key: "release-2023", Version: 3, CertPath: "release/chain"}
+	if vw.Target() != want {
+		t.Errorf("expected target %+v, got %+v", want, vw.Target())
+	}
+	if vw.profile.KVMount != "certs" {
+		t.Errorf("expected alias profile to be used, got %q", vw.profile.Name)
+	}
+
+	vw, err = NewVaultClient(context.Background(), config, "", "plain-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = Target{Profile: "dev", Key: "plain-key", CertPath: "plain-key"}
+	if vw.Target() != want {
+		t.Errorf("expected target %+v, got %+v", want, vw.Target())
+	}
+}
+
+func TestNewVaultClientKVAlias(t *testing.T) {
+	server := newFakeVault(t, map[string]any{
+		"/v1/secret/data/notation/aliases": map[string]any{
+			"data": map[string]any{
+				"release-signing": map[string]any{"key": "release-2023", "version": 2},
+				"nightly":         `{"key": "nightly-2023", "certPath": "nightly/chain"}`,
+				"broken":          `{"version": 1}`,
+			},
+		},
+	})
+	config := &Config{
+		Profiles: map[string]*Profile{
+			"dev": {Address: server.URL, Auth: &AuthConfig{Token: "root"}, AliasPath: "notation/aliases"},
+		},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]Target{
+		"release-signing": {Alias: "release-signing", Profile: "dev", Key: "release-2023", Version: 2, CertPath: "release-2023"},
+		"nightly":         {Alias: "nightly", Profile: "dev", Key: "nightly-2023", CertPath: "nightly/chain"},
+		"plain-key":       {Profile: "dev", Key: "plain-key", CertPath: "plain-key"},
+	}
+	for id, want := range tests {
+		vw, err := NewVaultClient(context.Background(), config, "", id)
+		if err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		if vw.Target() != want {
+			t.Errorf("%s: expected target %+v, got %+v", id, want, vw.Target())
+		}
+	}
+
+	if _, err := NewVaultClient(context.Background(), config, "", "broken"); err == nil || !strings.Contains(err.Error(), "key is required") {
+		t.Errorf("expected invalid alias error, got %v", err)
+	}
+
+	// a missing alias table falls back to the key of the same name
+	config.Profiles["dev"].AliasPath = "notation/missing"
+	vw, err := NewVaultClient(context.Background(), config, "", "plain-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Target{Profile: "dev", Key: "plain-key", CertPath: "plain-key"}); vw.Target() != want {
+		t.Errorf("expected target %+v, got %+v", want, vw.Target())
+	}
+}
+
+func TestConfigAliasValidation(t *testing.T) {
+	config := &Config{
+		Profiles: map[string]*Profile{"dev": {Address: "http://127.0.0.1:8200"}},
+		Aliases:  map[string]*Alias{"release-signing": {Profile: "prod", Key: "release"}},
+	}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), `profile "prod" not found`) {
+		t.Errorf("expected unknown profile error, got %v", err)
+	}
+}
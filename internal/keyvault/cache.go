@@ -0,0 +1,231 @@
+package keyvault
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault-client-go"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// EnvCacheDir overrides the directory of the local caches.
+const EnvCacheDir = "NOTATION_HC_VAULT_CACHE_DIR"
+
+// defaultKVv1CacheTTL bounds the staleness of chains read from KV v1, which
+// has no cheap way to detect changes.
+const defaultKVv1CacheTTL = 5 * time.Minute
+
+// metadataDeniedCacheTTL bounds the staleness of KV v2 chains whose metadata
+// the token is not allowed to read, which would otherwise cost a denied
+// metadata read and a secret read on every signature.
+const metadataDeniedCacheTTL = time.Minute
+
+// ChainCacheConfig configures the local cache of parsed certificate chains.
+type ChainCacheConfig struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// TTL is a Go duration during which a cached chain is used without
+	// contacting Vault. Past the TTL, a KV v2 chain is revalidated against
+	// the secret's current_version and a KV v1 chain is read again. Defaults
+	// to 0 for KV v2 and 5m for KV v1.
+	TTL string `json:"ttl,omitempty"`
+
+	ttl time.Duration
+}
+
+func (c *ChainCacheConfig) validate() error {
+	if c.TTL == "" {
+		return nil
+	}
+	ttl, err := time.ParseDuration(c.TTL)
+	if err != nil || ttl < 0 {
+		return fmt.Errorf("invalid ttl %q", c.TTL)
+	}
+	c.ttl = ttl
+	return nil
+}
+
+// chainCacheEntry is the on-disk form of a cached certificate chain.
+type chainCacheEntry struct {
+	Location     string    `json:"location"`
+	Version      int       `json:"version"`
+	KeyVersion   int       `json:"keyVersion,omitempty"`
+	FetchedAt    time.Time `json:"fetchedAt"`
+	Certificates [][]byte  `json:"certificates"`
+	// MetadataDenied records that the token cannot read the metadata of
+	// the secret, so that the entry cannot be revalidated cheaply.
+	MetadataDenied bool `json:"metadataDenied,omitempty"`
+}
+
+// chainCache caches the chain of one key on disk, keyed by the Vault
+// location of the chain.
+type chainCache struct {
+	path     string
+	location string
+	ttl      time.Duration
+}
+
+func newChainCache(profile *Profile, certPath string) (*chainCache, error) {
+	config := profile.ChainCache
+	if config == nil {
+		config = &ChainCacheConfig{}
+	}
+	if config.Disabled {
+		return nil, nil
+	}
+	dir, err := CacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := config.ttl
+	if config.TTL == "" && profile.KVVersion == 1 {
+		ttl = defaultKVv1CacheTTL
+	}
+	// the field layout is part of the key so that a config change is
+	// never served from a stale entry
+	location := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s", profile.Address, profile.Namespace, profile.KVLocation(certPath), profile.CertField, profile.LeafField, profile.ChainField, profile.Name)
+	if profile.ChainCompletion != nil {
+		// so is chain completion, so that a partial chain cached
+		// beforehand is completed on the next read
+		location += "|completed"
+	}
+	sum := sha256.Sum256([]byte(location))
+	return &chainCache{
+		path:     filepath.Join(dir, "chains", hex.EncodeToString(sum[:])+".json"),
+		location: location,
+		ttl:      ttl,
+	}, nil
+}
+
+// load returns the cached entry, or nil if there is no usable entry.
+func (c *chainCache) load() *chainCacheEntry {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil
+	}
+	var entry chainCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Location != c.location || len(entry.Certificates) == 0 {
+		return nil
+	}
+	return &entry
+}
+
+func (c *chainCache) fresh(entry *chainCacheEntry) bool {
+	ttl := c.ttl
+	if ttl == 0 && entry.MetadataDenied {
+		ttl = metadataDeniedCacheTTL
+	}
+	return ttl > 0 && time.Since(entry.FetchedAt) < ttl
+}
+
+func (c *chainCache) store(entry *chainCacheEntry) error {
+	entry.Location = c.location
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(c.path, data)
+}
+
+// CacheDir returns the directory of the local caches, each of which keeps
+// its entries in a subdirectory.
+func CacheDir() (string, error) {
+	if dir := os.Getenv(EnvCacheDir); dir != "" {
+		return dir, nil
+	}
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userCacheDir, configDirName), nil
+}
+
+// WriteFileAtomic writes data to a temporary file first and renames it to
+// path, so that concurrent signers never read a partially written file.
+func WriteFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (entry *chainCacheEntry) chain() (*CertificateChain, error) {
+	certs := make([]*x509.Certificate, 0, len(entry.Certificates))
+	for _, raw := range entry.Certificates {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return &CertificateChain{Certificates: certs, KeyVersion: entry.KeyVersion, Version: entry.Version}, nil
+}
+
+// currentKVVersion returns the current_version of a KV v2 secret from its
+// metadata, without reading the secret itself.
+func currentKVVersion(ctx context.Context, vw *VaultClientWrapper) (int, error) {
+	resp, err := vw.vaultClient.Read(ctx, vw.profile.kvPath("metadata", vw.target.CertPath))
+	if err != nil {
+		return 0, err
+	}
+	if resp == nil {
+		return 0, errors.New("empty metadata")
+	}
+	return toInt(resp.Data["current_version"]), nil
+}
+
+// cachedCertificateChain serves the chain from the cache when it is still
+// current, and refreshes the cache otherwise. Cache failures never fail the
+// read, they only cost a round-trip. A KV v2 chain whose metadata read is
+// denied is kept for metadataDeniedCacheTTL, unless a TTL is configured.
+func (vw *VaultClientWrapper) cachedCertificateChain(ctx context.Context, cache *chainCache) (*CertificateChain, error) {
+	var metadataDenied bool
+	entry := cache.load()
+	if entry != nil {
+		if cache.fresh(entry) {
+			if chain, err := entry.chain(); err == nil {
+				return chain, nil
+			}
+		} else if vw.profile.KVVersion == 2 {
+			version, err := currentKVVersion(ctx, vw)
+			if err == nil && version == entry.Version && version > 0 {
+				if chain, err := entry.chain(); err == nil {
+					entry.FetchedAt = time.Now()
+					cache.store(entry)
+					return chain, nil
+				}
+			}
+			metadataDenied = vault.IsErrorStatus(err, http.StatusForbidden)
+		}
+	}
+
+	chain, err := vw.readCertificateChain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entry = &chainCacheEntry{Version: chain.Version, KeyVersion: chain.KeyVersion, FetchedAt: time.Now(), MetadataDenied: metadataDenied}
+	for _, cert := range chain.Certificates {
+		entry.Certificates = append(entry.Certificates, cert.Raw)
+	}
+	cache.store(entry)
+	return chain, nil
+}
@@ -0,0 +1,143 @@
+package keyvault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// countingKV is a KV v2 stand-in holding a single secret that counts data and
+// metadata reads.
+type countingKV struct {
+	mu            sync.Mutex
+	version       int
+	data          map[string]any
+	dataReads     int
+	metadataReads int
+	// metadataDenied answers metadata reads with a permission error.
+	metadataDenied bool
+}
+
+func (kv *countingKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	var data map[string]any
+	switch r.URL.Path {
+	case "/v1/secret/data/signing":
+		kv.dataReads++
+		data = map[string]any{"data": kv.data, "metadata": map[string]any{"version": kv.version}}
+	case "/v1/secret/metadata/signing":
+		kv.metadataReads++
+		if kv.metadataDenied {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		data = map[string]any{"current_version": kv.version}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+func (kv *countingKV) reads() (int, int) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.dataReads, kv.metadataReads
+}
+
+func TestCachedCertificateChain(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Setenv(EnvCacheDir, cacheDir)
+	chain := testChain()
+	kv := &countingKV{version: 1, data: map[string]any{"certificate": encodePEM(chain)}}
+	server := httptest.NewServer(kv)
+	defer server.Close()
+
+	profile := &Profile{Name: "dev", Address: server.URL, Auth: &AuthConfig{Token: "root"}}
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(context.Background(), profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vw := &VaultClientWrapper{vaultClient: client, profile: profile, target: Target{Key: "signing", CertPath: "signing"}}
+
+	getChain := func(wantData, wantMetadata, wantCerts int) {
+		t.Helper()
+		certs, err := vw.GetCertificateChain(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(certs) != wantCerts {
+			t.Fatalf("expected %d certificates, got %d", wantCerts, len(certs))
+		}
+		if data, metadata := kv.reads(); data != wantData || metadata != wantMetadata {
+			t.Fatalf("expected %d data and %d metadata reads, got %d and %d", wantData, wantMetadata, data, metadata)
+		}
+	}
+
+	// cold cache reads the secret
+	getChain(1, 0, 2)
+	if entries, err := os.ReadDir(filepath.Join(cacheDir, "chains")); err != nil || len(entries) != 1 {
+		t.Fatalf("expected the chain cached in the chains subdirectory, got %v %v", entries, err)
+	}
+	// warm cache only checks the current version
+	getChain(1, 1, 2)
+	getChain(1, 2, 2)
+
+	// a rotated certificate is picked up on the next read
+	kv.mu.Lock()
+	kv.version = 2
+	kv.data = map[string]any{"certificate": encodePEM(chain[:1])}
+	kv.mu.Unlock()
+	getChain(2, 3, 1)
+	getChain(2, 4, 1)
+
+	// within the TTL, Vault is not contacted at all
+	profile.ChainCache = &ChainCacheConfig{TTL: "1h"}
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	getChain(2, 4, 1)
+	getChain(2, 4, 1)
+
+	// a disabled cache always reads the secret
+	profile.ChainCache = &ChainCacheConfig{Disabled: true}
+	getChain(3, 4, 1)
+}
+
+func TestCachedCertificateChainMetadataDenied(t *testing.T) {
+	t.Setenv(EnvCacheDir, t.TempDir())
+	kv := &countingKV{version: 1, data: map[string]any{"certificate": encodePEM(testChain())}, metadataDenied: true}
+	server := httptest.NewServer(kv)
+	defer server.Close()
+
+	profile := &Profile{Name: "dev", Address: server.URL, Auth: &AuthConfig{Token: "root"}}
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(context.Background(), profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vw := &VaultClientWrapper{vaultClient: client, profile: profile, target: Target{Key: "signing", CertPath: "signing"}}
+
+	for i := 0; i < 4; i++ {
+		if _, err := vw.GetCertificateChain(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the denied metadata read is only attempted once, the entry is then
+	// served without contacting Vault
+	if data, metadata := kv.reads(); data != 2 || metadata != 1 {
+		t.Errorf("expected 2 data reads and 1 metadata read, got %d and %d", data, metadata)
+	}
+}
@@ -0,0 +1,154 @@
+package keyvault
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// keyVersionField is the KV field pairing a certificate chain with the
+// transit key version it certifies.
+const keyVersionField = "key_version"
+
+// CertificateChain is a certificate chain read from KV.
+type CertificateChain struct {
+	// Certificates holds the chain, leaf first.
+	Certificates []*x509.Certificate
+	// KeyVersion is the transit key version the chain is paired with, 0 if
+	// the chain is not paired and certifies the latest version.
+	KeyVersion int
+	// Version is the version of the KV v2 secret, 0 for KV v1.
+	Version int
+}
+
+// certificatesFromSecret extracts the certificate chain, leaf first, from a
+// KV secret laid out as configured by the profile.
+func (p *Profile) certificatesFromSecret(data map[string]interface{}, location string) ([]*x509.Certificate, error) {
+	if p.LeafField == "" {
+		certs, err := certificatesFromField(data, p.CertField, location)
+		if err != nil {
+			return nil, err
+		}
+		return certs, nil
+	}
+
+	leaf, err := certificatesFromField(data, p.LeafField, location)
+	if err != nil {
+		return nil, err
+	}
+	if len(leaf) != 1 {
+		return nil, fmt.Errorf("field %q of secret %s must hold exactly one certificate, found %d", p.LeafField, location, len(leaf))
+	}
+	if _, ok := data[p.ChainField]; !ok {
+		// a self-signed leaf needs no chain
+		return leaf, nil
+	}
+	chain, err := certificatesFromField(data, p.ChainField, location)
+	if err != nil {
+		return nil, err
+	}
+	return append(leaf, chain...), nil
+}
+
+func certificatesFromField(data map[string]interface{}, field string, location string) ([]*x509.Certificate, error) {
+	value, ok := data[field]
+	if !ok {
+		return nil, fmt.Errorf("field %q not found in secret %s, available fields: [%s]", field, location, strings.Join(fieldNames(data), ", "))
+	}
+	certs, err := decodeCertificates(value)
+	if err != nil {
+		return nil, fmt.Errorf("field %q of secret %s: %v", field, location, err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("field %q of secret %s holds no certificates", field, location)
+	}
+	return certs, nil
+}
+
+// decodeCertificates decodes a KV value holding PEM certificates, base64
+// encoded DER certificates, or a list of either.
+func decodeCertificates(value interface{}) ([]*x509.Certificate, error) {
+	switch v := value.(type) {
+	case string:
+		return decodeCertificateString(v)
+	case []interface{}:
+		var certs []*x509.Certificate
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("item %d has unexpected type %T, expected a string", i, item)
+			}
+			decoded, err := decodeCertificateString(s)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %v", i, err)
+			}
+			certs = append(certs, decoded...)
+		}
+		return certs, nil
+	case nil:
+		return nil, errors.New("value is empty")
+	default:
+		return nil, fmt.Errorf("unexpected type %T, expected a string or a list of strings", value)
+	}
+}
+
+func decodeCertificateString(s string) ([]*x509.Certificate, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errors.New("value is empty")
+	}
+	certs, err := ParseCertificates([]byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificates, %v", err)
+	}
+	return certs, nil
+}
+
+// CertificateData returns the KV secret data holding certs, leaf first, laid
+// out as configured by the profile and paired with keyVersion if it is not 0.
+func (p *Profile) CertificateData(certs []*x509.Certificate, keyVersion int) map[string]interface{} {
+	var data map[string]interface{}
+	if p.LeafField == "" {
+		data = map[string]interface{}{p.CertField: encodePEM(certs)}
+	} else {
+		data = map[string]interface{}{p.LeafField: encodePEM(certs[:1])}
+		if p.ChainField != "" && len(certs) > 1 {
+			data[p.ChainField] = encodePEM(certs[1:])
+		}
+	}
+	if keyVersion != 0 {
+		data[keyVersionField] = keyVersion
+	}
+	return data
+}
+
+// isCertificateField reports whether the KV field is written by
+// CertificateData.
+func (p *Profile) isCertificateField(field string) bool {
+	switch field {
+	case p.CertField, p.LeafField, p.ChainField, keyVersionField:
+		return field != ""
+	}
+	return false
+}
+
+func encodePEM(certs []*x509.Certificate) string {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.String()
+}
+
+func fieldNames(data map[string]interface{}) []string {
+	names := make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,152 @@
+package keyvault
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"github.com/notaryproject/notation-core-go/testhelper"
+	"strings"
+	"testing"
+)
+
+func testChain() []*x509.Certificate {
+	return []*x509.Certificate{testhelper.GetRSALeafCertificate().Cert, testhelper.GetRSARootCertificate().Cert}
+}
+
+func TestCertificatesFromSecret(t *testing.T) {
+	chain := testChain()
+	leafPEM, rootPEM := encodePEM(chain[:1]), encodePEM(chain[1:])
+	leafB64 := base64.StdEncoding.EncodeToString(chain[0].Raw)
+	rootB64 := base64.StdEncoding.EncodeToString(chain[1].Raw)
+
+	combined := &Profile{CertField: "certificate"}
+	split := &Profile{CertField: "certificate", LeafField: "leaf", ChainField: "chain"}
+	tests := map[string]struct {
+		profile *Profile
+		data    map[string]interface{}
+		want    int
+		errMsg  string
+	}{
+		"pem chain": {
+			profile: combined,
+			data:    map[string]interface{}{"certificate": leafPEM + rootPEM},
+			want:    2,
+		},
+		"base64 der": {
+			profile: combined,
+			data:    map[string]interface{}{"certificate": leafB64},
+			want:    1,
+		},
+		"list of base64 der": {
+			profile: combined,
+			data:    map[string]interface{}{"certificate": []interface{}{leafB64, rootB64}},
+			want:    2,
+		},
+		"separate fields": {
+			profile: split,
+			data:    map[string]interface{}{"leaf": leafPEM, "chain": rootB64},
+			want:    2,
+		},
+		"separate fields without chain": {
+			profile: split,
+			data:    map[string]interface{}{"leaf": leafPEM},
+			want:    1,
+		},
+		"missing field": {
+			profile: combined,
+			data:    map[string]interface{}{"openssl-key": leafPEM},
+			errMsg:  `field "certificate" not found in secret secret/key, available fields: [openssl-key]`,
+		},
+		"wrong type": {
+			profile: combined,
+			data:    map[string]interface{}{"certificate": map[string]interface{}{"pem": leafPEM}},
+			errMsg:  "unexpected type",
+		},
+		"garbage": {
+			profile: combined,
+			data:    map[string]interface{}{"certificate": "not a certificate!"},
+			errMsg:  "neither PEM, DER, PKCS #7 nor base64 encoded DER",
+		},
+		"leaf field with chain": {
+			profile: split,
+			data:    map[string]interface{}{"leaf": leafPEM + rootPEM},
+			errMsg:  "exactly one certificate",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			certs, err := tt.profile.certificatesFromSecret(tt.data, "secret/key")
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("expected error containing %q, got %v", tt.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(certs) != tt.want {
+				t.Fatalf("expected %d certificates, got %d", tt.want, len(certs))
+			}
+			if !certs[0].Equal(chain[0]) {
+				t.Error("expected leaf certificate first")
+			}
+		})
+	}
+}
+
+func TestCertificateDataRoundTrip(t *testing.T) {
+	chain := testChain()
+	for _, profile := range []*Profile{
+		{CertField: "certificate"},
+		{LeafField: "leaf", ChainField: "chain"},
+	} {
+		certs, err := profile.certificatesFromSecret(profile.CertificateData(chain, 0), "secret/key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(certs) != 2 || !certs[0].Equal(chain[0]) || !certs[1].Equal(chain[1]) {
+			t.Errorf("chain did not round trip for profile %+v", profile)
+		}
+	}
+}
+
+func TestGetCertificateChainKVVersions(t *testing.T) {
+	chainPEM := encodePEM(testChain())
+	server := newFakeVault(t, map[string]any{
+		"/v1/secret/data/signing": map[string]any{
+			"data":     map[string]any{"certificate": chainPEM},
+			"metadata": map[string]any{"version": 4},
+		},
+		"/v1/kv/signing": map[string]any{"pem": chainPEM},
+	})
+
+	for _, profile := range []*Profile{
+		{Address: server.URL, Auth: &AuthConfig{Token: "root"}},
+		{Address: server.URL, Auth: &AuthConfig{Token: "root"}, KVVersion: 1, KVMount: "kv", CertField: "pem"},
+	} {
+		if err := profile.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		client, err := NewClient(context.Background(), profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vw := &VaultClientWrapper{vaultClient: client, profile: profile, target: Target{Key: "signing", CertPath: "signing"}}
+		certs, err := vw.GetCertificateChain(context.Background())
+		if err != nil {
+			t.Fatalf("kv v%d: %v", profile.KVVersion, err)
+		}
+		if len(certs) != 2 {
+			t.Errorf("kv v%d: expected 2 certificates, got %d", profile.KVVersion, len(certs))
+		}
+	}
+
+	profile := &Profile{Address: server.URL, Auth: &AuthConfig{Token: "root"}}
+	profile.Validate()
+	client, _ := NewClient(context.Background(), profile)
+	vw := &VaultClientWrapper{vaultClient: client, profile: profile, target: Target{Key: "missing", CertPath: "missing"}}
+	if _, err := vw.GetCertificateChain(context.Background()); err == nil || !strings.Contains(err.Error(), "secret secret/missing not found") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
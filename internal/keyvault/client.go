@@ -0,0 +1,131 @@
+package keyvault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// NewClient returns a Vault client for the profile, authenticated with the
+// profile's auth method.
+func NewClient(ctx context.Context, profile *Profile) (*vault.Client, error) {
+	client, err := newClient(profile)
+	if err != nil {
+		return nil, err
+	}
+	token, err := login(ctx, client, profile.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate to vault with profile %q, %v", profile.Name, err)
+	}
+	if err := client.SetToken(token); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// newClient returns an unauthenticated Vault client for the profile.
+func newClient(profile *Profile) (*vault.Client, error) {
+	options := []vault.ClientOption{
+		vault.WithAddress(profile.Address),
+		vault.WithRequestTimeout(30 * time.Second),
+	}
+	if t := profile.TLS; t != nil {
+		options = append(options, vault.WithTLS(vault.TLSConfiguration{
+			ServerCertificate: vault.ServerCertificateEntry{
+				FromFile:      t.CACert,
+				FromDirectory: t.CAPath,
+			},
+			ClientCertificate:    vault.ClientCertificateEntry{FromFile: t.ClientCert},
+			ClientCertificateKey: vault.ClientCertificateKeyEntry{FromFile: t.ClientKey},
+			ServerName:           t.ServerName,
+			InsecureSkipVerify:   t.InsecureSkipVerify,
+		}))
+	}
+	client, err := vault.New(options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vault client for profile %q, %v", profile.Name, err)
+	}
+	if profile.Namespace != "" {
+		if err := client.SetNamespace(profile.Namespace); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func login(ctx context.Context, client *vault.Client, auth *AuthConfig) (string, error) {
+	if auth == nil {
+		auth = &AuthConfig{Method: AuthMethodToken}
+	}
+	var resp *vault.Response[map[string]interface{}]
+	var err error
+	switch auth.Method {
+	case "", AuthMethodToken:
+		return readToken(auth)
+	case AuthMethodAppRole:
+		var secretID string
+		if secretID, err = valueOrFile(auth.SecretID, auth.SecretIDFile); err != nil {
+			return "", err
+		}
+		resp, err = client.Auth.AppRoleLogin(ctx, schema.AppRoleLoginRequest{
+			RoleId:   auth.RoleID,
+			SecretId: secretID,
+		}, vault.WithMountPath(auth.Mount))
+	case AuthMethodKubernetes:
+		var jwt string
+		if jwt, err = valueOrFile("", auth.JWTFile); err != nil {
+			return "", err
+		}
+		resp, err = client.Auth.KubernetesLogin(ctx, schema.KubernetesLoginRequest{
+			Jwt:  jwt,
+			Role: auth.Role,
+		}, vault.WithMountPath(auth.Mount))
+	default:
+		return "", fmt.Errorf("unsupported auth method %q", auth.Method)
+	}
+	if err != nil {
+		return "", err
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("%s login returned no client token", auth.Method)
+	}
+	return resp.Auth.ClientToken, nil
+}
+
+// readToken returns the configured token, falling back to VAULT_TOKEN and
+// the vault CLI token helper file.
+func readToken(auth *AuthConfig) (string, error) {
+	token, err := valueOrFile(auth.Token, auth.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			token, _ = valueOrFile("", filepath.Join(home, ".vault-token"))
+		}
+	}
+	if token == "" {
+		return "", errors.New("no vault token configured, set auth.token, auth.tokenFile or VAULT_TOKEN")
+	}
+	return token, nil
+}
+
+func valueOrFile(value, path string) (string, error) {
+	if value != "" || path == "" {
+		return value, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,524 @@
+package keyvault
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// EnvConfigPath overrides the location of the plugin configuration file.
+	EnvConfigPath = "NOTATION_HC_VAULT_CONFIG"
+	// EnvProfile selects the profile when the plugin config does not.
+	EnvProfile = "NOTATION_HC_VAULT_PROFILE"
+
+	// PluginConfigProfile is the notation plugin config key selecting a profile,
+	// e.g. `notation sign --plugin-config profile=prod`.
+	PluginConfigProfile = "profile"
+	// PluginConfigFile is the notation plugin config key overriding the
+	// configuration file path.
+	PluginConfigFile = "config"
+
+	configDirName  = "notation-hc-vault"
+	configFileName = "config.json"
+)
+
+const (
+	AuthMethodToken      = "token"
+	AuthMethodAppRole    = "approle"
+	AuthMethodKubernetes = "kubernetes"
+
+	defaultTransitMount = "transit"
+	defaultKVMount      = "secret"
+	defaultCertField    = "certificate"
+	defaultK8sJWTFile   = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	defaultRevocationTimeout = 10 * time.Second
+	defaultMaxCRLSize        = 10 << 20
+
+	defaultTimestampTimeout = 10 * time.Second
+)
+
+// Config reflects the plugin configuration file.
+type Config struct {
+	// DefaultProfile is used when no profile is selected explicitly.
+	DefaultProfile string              `json:"defaultProfile,omitempty"`
+	Profiles       map[string]*Profile `json:"profiles"`
+	// Aliases maps friendly key IDs to concrete Vault locations.
+	Aliases map[string]*Alias `json:"aliases,omitempty"`
+}
+
+// Profile holds the settings needed to reach one Vault deployment.
+type Profile struct {
+	// Name is populated from the key of the profile in the config file.
+	Name string `json:"-"`
+
+	Address      string      `json:"address"`
+	Namespace    string      `json:"namespace,omitempty"`
+	TLS          *TLSConfig  `json:"tls,omitempty"`
+	Auth         *AuthConfig `json:"auth,omitempty"`
+	TransitMount string      `json:"transitMount,omitempty"`
+	KVMount      string      `json:"kvMount,omitempty"`
+	// KVVersion is the version of the KV secrets engine at KVMount, 1 or 2.
+	KVVersion int `json:"kvVersion,omitempty"`
+	// CertField is the KV field holding the whole chain, leaf first.
+	CertField string `json:"certField,omitempty"`
+	// LeafField and ChainField hold the leaf and the rest of the chain in
+	// separate KV fields, taking precedence over CertField.
+	LeafField  string `json:"leafField,omitempty"`
+	ChainField string `json:"chainField,omitempty"`
+	// ChainCache configures the local certificate chain cache.
+	ChainCache *ChainCacheConfig `json:"chainCache,omitempty"`
+	// ChainCompletion, if set, completes partial chains by following the
+	// caIssuers URLs of their certificates.
+	ChainCompletion *ChainCompletionConfig `json:"chainCompletion,omitempty"`
+	// RevocationCheck, if set, checks the revocation status of the leaf
+	// certificate with OCSP and CRLs before signing.
+	RevocationCheck *RevocationConfig `json:"revocationCheck,omitempty"`
+	// Timestamp, if set, makes the plugin generate the signature envelopes
+	// itself, countersigned by an RFC 3161 time stamping authority.
+	Timestamp *TimestampConfig `json:"timestamp,omitempty"`
+	// ExpiryWarning is the certificate lifetime left, a Go duration or a
+	// number of days such as 30d, below which describe-key and doctor warn
+	// that renewal is due. Defaults to 30d.
+	ExpiryWarning string `json:"expiryWarning,omitempty"`
+	// AliasPath is an optional KV secret, in KVMount, holding an alias table
+	// shared by all users of the profile.
+	AliasPath string `json:"aliasPath,omitempty"`
+
+	expiryWarning time.Duration
+}
+
+// TLSConfig configures the TLS connection to Vault. File paths are PEM files.
+type TLSConfig struct {
+	CACert             string `json:"caCert,omitempty"`
+	CAPath             string `json:"caPath,omitempty"`
+	ClientCert         string `json:"clientCert,omitempty"`
+	ClientKey          string `json:"clientKey,omitempty"`
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+}
+
+// AuthConfig configures how the plugin obtains a Vault token.
+type AuthConfig struct {
+	// Method is one of token, approle or kubernetes. Defaults to token.
+	Method string `json:"method,omitempty"`
+	// Mount is the auth method mount path, defaults to the method name.
+	Mount string `json:"mount,omitempty"`
+
+	// token
+	Token     string `json:"token,omitempty"`
+	TokenFile string `json:"tokenFile,omitempty"`
+
+	// approle
+	RoleID       string `json:"roleId,omitempty"`
+	SecretID     string `json:"secretId,omitempty"`
+	SecretIDFile string `json:"secretIdFile,omitempty"`
+
+	// kubernetes
+	Role    string `json:"role,omitempty"`
+	JWTFile string `json:"jwtFile,omitempty"`
+}
+
+// RevocationConfig configures the revocation check of the leaf certificate
+// before signing.
+type RevocationConfig struct {
+	// Timeout is a Go duration bounding each OCSP request and CRL download,
+	// default 10s.
+	Timeout string `json:"timeout,omitempty"`
+	// MaxCRLSize is the maximum size in bytes of a CRL, default 10MiB.
+	MaxCRLSize int64 `json:"maxCRLSize,omitempty"`
+	// FailOpen signs when the revocation status cannot be determined, for
+	// example when no responder is reachable. By default signing fails.
+	FailOpen bool `json:"failOpen,omitempty"`
+	// DisableCache queries the responders on every signature instead of
+	// caching their responses on disk until their next update.
+	DisableCache bool `json:"disableCache,omitempty"`
+
+	timeout time.Duration
+}
+
+func (c *RevocationConfig) validate() error {
+	c.timeout = defaultRevocationTimeout
+	if c.Timeout != "" {
+		timeout, err := time.ParseDuration(c.Timeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %q", c.Timeout)
+		}
+		c.timeout = timeout
+	}
+	switch {
+	case c.MaxCRLSize < 0:
+		return fmt.Errorf("invalid maxCRLSize %d", c.MaxCRLSize)
+	case c.MaxCRLSize == 0:
+		c.MaxCRLSize = defaultMaxCRLSize
+	}
+	return nil
+}
+
+// RequestTimeout returns the timeout of each OCSP request and CRL download.
+func (c *RevocationConfig) RequestTimeout() time.Duration {
+	if c.timeout == 0 {
+		return defaultRevocationTimeout
+	}
+	return c.timeout
+}
+
+// TimestampConfig configures the RFC 3161 time stamping authority that
+// countersigns the generated envelopes.
+type TimestampConfig struct {
+	// URL is the endpoint of the time stamping authority.
+	URL string `json:"url"`
+	// RootCert is a PEM file with the root certificates the TSA chains to.
+	// Defaults to the system roots.
+	RootCert string `json:"rootCert,omitempty"`
+	// Timeout is a Go duration bounding the timestamp request, default 10s.
+	Timeout string `json:"timeout,omitempty"`
+
+	timeout time.Duration
+}
+
+func (c *TimestampConfig) validate() error {
+	u, err := url.Parse(c.URL)
+	if c.URL == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid url %q, must be an http(s) URL", c.URL)
+	}
+	c.timeout = defaultTimestampTimeout
+	if c.Timeout != "" {
+		timeout, err := time.ParseDuration(c.Timeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %q", c.Timeout)
+		}
+		c.timeout = timeout
+	}
+	return nil
+}
+
+// RequestTimeout returns the timeout of the timestamp request.
+func (c *TimestampConfig) RequestTimeout() time.Duration {
+	if c.timeout == 0 {
+		return defaultTimestampTimeout
+	}
+	return c.timeout
+}
+
+// DefaultConfigPath returns $XDG_CONFIG_HOME/notation-hc-vault/config.json or
+// its platform equivalent, unless overridden by NOTATION_HC_VAULT_CONFIG.
+func DefaultConfigPath() (string, error) {
+	if path := os.Getenv(EnvConfigPath); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, configDirName, configFileName), nil
+}
+
+// LoadConfig reads and validates the configuration file at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var config Config
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("malformed config file %s: %w", path, err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// Validate checks the config against the schema and fills in defaults.
+func (c *Config) Validate() error {
+	if len(c.Profiles) == 0 {
+		return errors.New("no profiles defined")
+	}
+	for _, name := range c.ProfileNames() {
+		profile := c.Profiles[name]
+		if profile == nil {
+			return fmt.Errorf("profile %q is empty", name)
+		}
+		profile.Name = name
+		if err := profile.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.DefaultProfile != "" {
+		if _, ok := c.Profiles[c.DefaultProfile]; !ok {
+			return fmt.Errorf("default profile %q not found", c.DefaultProfile)
+		}
+	}
+	for name, alias := range c.Aliases {
+		if alias == nil {
+			return fmt.Errorf("alias %q is empty", name)
+		}
+		if err := alias.validate(name); err != nil {
+			return err
+		}
+		if _, ok := c.Profiles[alias.Profile]; alias.Profile != "" && !ok {
+			return fmt.Errorf("alias %q: profile %q not found", name, alias.Profile)
+		}
+	}
+	return nil
+}
+
+// Profile returns the named profile, or the default one if name is empty.
+func (c *Config) Profile(name string) (*Profile, error) {
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		if len(c.Profiles) != 1 {
+			return nil, fmt.Errorf("no profile selected and no default profile set, available profiles: %s", strings.Join(c.ProfileNames(), ", "))
+		}
+		name = c.ProfileNames()[0]
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %q not found, available profiles: %s", name, strings.Join(c.ProfileNames(), ", "))
+	}
+	return profile, nil
+}
+
+// ProfileNames returns the sorted names of the configured profiles.
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Timestamps reports whether any profile of the config timestamps its
+// signatures. A key ID can resolve to any of them through an alias, so this
+// is what decides between raw signatures and generated envelopes.
+func (c *Config) Timestamps() bool {
+	if c == nil {
+		return false
+	}
+	for _, profile := range c.Profiles {
+		if profile.Timestamp != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the profile settings and fills in defaults.
+func (p *Profile) Validate() error {
+	fail := func(format string, args ...any) error {
+		return fmt.Errorf("profile %q: %s", p.Name, fmt.Sprintf(format, args...))
+	}
+	if p.Address == "" {
+		return fail("address is required")
+	}
+	u, err := url.Parse(p.Address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fail("address %q must be an http(s) URL", p.Address)
+	}
+	for field, mount := range map[string]*string{
+		"transitMount": &p.TransitMount,
+		"kvMount":      &p.KVMount,
+	} {
+		if strings.HasPrefix(*mount, "/") || strings.HasSuffix(*mount, "/") {
+			return fail("%s %q must not start or end with '/'", field, *mount)
+		}
+	}
+	if p.TransitMount == "" {
+		p.TransitMount = defaultTransitMount
+	}
+	if p.KVMount == "" {
+		p.KVMount = defaultKVMount
+	}
+	switch p.KVVersion {
+	case 0:
+		p.KVVersion = 2
+	case 1, 2:
+	default:
+		return fail("kvVersion must be 1 or 2, got %d", p.KVVersion)
+	}
+	if (p.LeafField == "") != (p.ChainField == "") {
+		return fail("leafField and chainField must be set together")
+	}
+	if p.LeafField != "" && p.LeafField == p.ChainField {
+		return fail("leafField and chainField must differ")
+	}
+	if p.CertField == "" {
+		p.CertField = defaultCertField
+	}
+	if p.ChainCache != nil {
+		if err := p.ChainCache.validate(); err != nil {
+			return fail("chainCache: %v", err)
+		}
+	}
+	if p.ChainCompletion != nil {
+		if err := p.ChainCompletion.validate(); err != nil {
+			return fail("chainCompletion: %v", err)
+		}
+	}
+	if p.RevocationCheck != nil {
+		if err := p.RevocationCheck.validate(); err != nil {
+			return fail("revocationCheck: %v", err)
+		}
+	}
+	if p.Timestamp != nil {
+		if err := p.Timestamp.validate(); err != nil {
+			return fail("timestamp: %v", err)
+		}
+	}
+	if p.ExpiryWarning != "" {
+		window, err := ParseDuration(p.ExpiryWarning)
+		if err != nil || window < 0 {
+			return fail("invalid expiryWarning %q", p.ExpiryWarning)
+		}
+		p.expiryWarning = window
+	}
+	if p.TLS != nil && (p.TLS.ClientCert == "") != (p.TLS.ClientKey == "") {
+		return fail("tls.clientCert and tls.clientKey must be set together")
+	}
+	if p.Auth == nil {
+		p.Auth = &AuthConfig{}
+	}
+	if err := p.Auth.validate(); err != nil {
+		return fail("auth: %v", err)
+	}
+	return nil
+}
+
+func (a *AuthConfig) validate() error {
+	if a.Method == "" {
+		a.Method = AuthMethodToken
+	}
+	switch a.Method {
+	case AuthMethodToken:
+		if a.Token != "" && a.TokenFile != "" {
+			return errors.New("token and tokenFile are mutually exclusive")
+		}
+	case AuthMethodAppRole:
+		if a.RoleID == "" {
+			return errors.New("roleId is required for approle")
+		}
+		if a.SecretID != "" && a.SecretIDFile != "" {
+			return errors.New("secretId and secretIdFile are mutually exclusive")
+		}
+	case AuthMethodKubernetes:
+		if a.Role == "" {
+			return errors.New("role is required for kubernetes")
+		}
+		if a.JWTFile == "" {
+			a.JWTFile = defaultK8sJWTFile
+		}
+	default:
+		return fmt.Errorf("unsupported method %q, must be one of %s, %s, %s", a.Method, AuthMethodToken, AuthMethodAppRole, AuthMethodKubernetes)
+	}
+	if a.Mount == "" {
+		a.Mount = a.Method
+	}
+	return nil
+}
+
+// LoadProfile resolves the profile to use. The config file path and the
+// profile name are taken from the plugin config first, then from the
+// environment. Without a config file, a profile is built from the standard
+// VAULT_* environment variables.
+func LoadProfile(pluginConfig map[string]string) (*Profile, error) {
+	return LoadProfileFrom(pluginConfig[PluginConfigFile], selectedProfile(pluginConfig))
+}
+
+func selectedProfile(pluginConfig map[string]string) string {
+	if name := pluginConfig[PluginConfigProfile]; name != "" {
+		return name
+	}
+	return os.Getenv(EnvProfile)
+}
+
+// LoadProfileFrom resolves the named profile from the config file at path,
+// or from the default config path if path is empty.
+func LoadProfileFrom(path, name string) (*Profile, error) {
+	config, err := ResolveConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return config.SelectProfile(name)
+}
+
+// ResolveConfig loads the config file at path, or at the default config path
+// if path is empty. A missing config file at the default location is not an
+// error and yields a nil config.
+func ResolveConfig(path string) (*Config, error) {
+	explicit := path != ""
+	if !explicit {
+		var err error
+		if path, err = DefaultConfigPath(); err != nil {
+			return nil, err
+		}
+		explicit = os.Getenv(EnvConfigPath) != ""
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && !explicit {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return config, nil
+}
+
+// SelectProfile returns the named profile. On a nil config, the profile is
+// built from the environment.
+func (c *Config) SelectProfile(name string) (*Profile, error) {
+	if c == nil {
+		if name != "" {
+			return nil, fmt.Errorf("profile %q selected but no config file found", name)
+		}
+		return profileFromEnv()
+	}
+	return c.Profile(name)
+}
+
+// profileFromEnv builds a profile from the VAULT_* environment variables
+// understood by the vault CLI.
+func profileFromEnv() (*Profile, error) {
+	var skipVerify bool
+	if v := os.Getenv("VAULT_SKIP_VERIFY"); v != "" {
+		var err error
+		if skipVerify, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid VAULT_SKIP_VERIFY %q", v)
+		}
+	}
+	profile := &Profile{
+		Name:      "env",
+		Address:   os.Getenv("VAULT_ADDR"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+		TLS: &TLSConfig{
+			CACert:             os.Getenv("VAULT_CACERT"),
+			CAPath:             os.Getenv("VAULT_CAPATH"),
+			ClientCert:         os.Getenv("VAULT_CLIENT_CERT"),
+			ClientKey:          os.Getenv("VAULT_CLIENT_KEY"),
+			ServerName:         os.Getenv("VAULT_TLS_SERVER_NAME"),
+			InsecureSkipVerify: skipVerify,
+		},
+	}
+	if profile.Address == "" {
+		return nil, errors.New("no config file found and VAULT_ADDR is not set")
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
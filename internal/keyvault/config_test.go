@@ -0,0 +1,192 @@
+package keyvault
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `{
+	"defaultProfile": "dev",
+	"profiles": {
+		"dev": {"address": "http://127.0.0.1:8200"},
+		"prod": {
+			"address": "https://vault.example.com",
+			"namespace": "signing",
+			"tls": {"caCert": "/etc/ssl/vault-ca.pem"},
+			"auth": {"method": "approle", "roleId": "notation", "secretIdFile": "/run/secrets/secret-id"},
+			"transitMount": "notation-transit",
+			"kvMount": "notation-kv",
+			"certField": "chain"
+		}
+	}
+}`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dev, err := config.Profile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.Name != "dev" || dev.TransitMount != "transit" || dev.KVMount != "secret" || dev.CertField != "certificate" || dev.Auth.Method != AuthMethodToken {
+		t.Errorf("unexpected defaults for dev profile: %+v", dev)
+	}
+
+	prod, err := config.Profile("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prod.TransitMount != "notation-transit" || prod.KVMount != "notation-kv" || prod.CertField != "chain" || prod.Auth.Mount != "approle" {
+		t.Errorf("unexpected prod profile: %+v", prod)
+	}
+
+	if _, err := config.Profile("staging"); err == nil || !strings.Contains(err.Error(), "dev, prod") {
+		t.Errorf("expected not found error listing profiles, got %v", err)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := map[string]struct {
+		config string
+		errMsg string
+	}{
+		"unknown field": {
+			config: `{"profiles": {"dev": {"address": "http://127.0.0.1:8200", "adress": "x"}}}`,
+			errMsg: `unknown field "adress"`,
+		},
+		"no profiles": {
+			config: `{"profiles": {}}`,
+			errMsg: "no profiles defined",
+		},
+		"missing address": {
+			config: `{"profiles": {"dev": {}}}`,
+			errMsg: `profile "dev": address is required`,
+		},
+		"bad address": {
+			config: `{"profiles": {"dev": {"address": "127.0.0.1:8200"}}}`,
+			errMsg: "must be an http(s) URL",
+		},
+		"bad mount": {
+			config: `{"profiles": {"dev": {"address": "http://127.0.0.1:8200", "kvMount": "/secret/"}}}`,
+			errMsg: "kvMount",
+		},
+		"unknown auth method": {
+			config: `{"profiles": {"dev": {"address": "http://127.0.0.1:8200", "auth": {"method": "ldap"}}}}`,
+			errMsg: `unsupported method "ldap"`,
+		},
+		"approle without role id": {
+			config: `{"profiles": {"dev": {"address": "http://127.0.0.1:8200", "auth": {"method": "approle"}}}}`,
+			errMsg: "roleId is required",
+		},
+		"missing default": {
+			config: `{"defaultProfile": "prod", "profiles": {"dev": {"address": "http://127.0.0.1:8200"}}}`,
+			errMsg: `default profile "prod" not found`,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestLoadProfileFromEnv(t *testing.T) {
+	t.Setenv(EnvConfigPath, "")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("VAULT_ADDR", "http://127.0.0.1:8200")
+
+	profile, err := LoadProfile(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Address != "http://127.0.0.1:8200" || profile.KVMount != "secret" {
+		t.Errorf("unexpected profile from environment: %+v", profile)
+	}
+
+	if _, err := LoadProfile(map[string]string{PluginConfigProfile: "prod"}); err == nil {
+		t.Error("expected error selecting a profile without a config file")
+	}
+
+	for value, skip := range map[string]bool{"false": false, "0": false, "true": true, "1": true} {
+		t.Setenv("VAULT_SKIP_VERIFY", value)
+		profile, err := LoadProfile(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if profile.TLS.InsecureSkipVerify != skip {
+			t.Errorf("VAULT_SKIP_VERIFY=%s: expected InsecureSkipVerify %v", value, skip)
+		}
+	}
+	t.Setenv("VAULT_SKIP_VERIFY", "maybe")
+	if _, err := LoadProfile(nil); err == nil {
+		t.Error("expected error for an invalid VAULT_SKIP_VERIFY")
+	}
+}
+
+func TestConfigTimestamps(t *testing.T) {
+	config, err := LoadConfig(writeConfig(t, `{"profiles": {
+		"dev": {"address": "http://127.0.0.1:8200"},
+		"prod": {"address": "https://vault.example.com"}
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Timestamps() {
+		t.Error("expected no timestamps without a timestamp config")
+	}
+
+	// the timestamping profile is only reachable through an alias
+	config, err = LoadConfig(writeConfig(t, `{"defaultProfile": "dev", "profiles": {
+		"dev": {"address": "http://127.0.0.1:8200"},
+		"prod": {"address": "https://vault.example.com", "timestamp": {"url": "https://tsa.example.com/tsr"}}
+	}, "aliases": {"release": {"profile": "prod", "key": "release"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.Timestamps() {
+		t.Error("expected timestamps from the aliased profile")
+	}
+	if (*Config)(nil).Timestamps() {
+		t.Error("expected no timestamps without a config file")
+	}
+}
+
+func TestLoadProfileFromPluginConfig(t *testing.T) {
+	path := writeConfig(t, `{"profiles": {
+		"dev": {"address": "http://127.0.0.1:8200"},
+		"prod": {"address": "https://vault.example.com"}
+	}}`)
+	t.Setenv(EnvProfile, "dev")
+
+	profile, err := LoadProfile(map[string]string{PluginConfigFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Name != "dev" {
+		t.Errorf("expected profile from environment, got %q", profile.Name)
+	}
+
+	profile, err = LoadProfile(map[string]string{PluginConfigFile: path, PluginConfigProfile: "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Name != "prod" {
+		t.Errorf("expected profile from plugin config, got %q", profile.Name)
+	}
+}
@@ -0,0 +1,319 @@
+package keyvault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/crypto"
+	"github.com/hashicorp/vault-client-go"
+	"strings"
+	"time"
+)
+
+// CheckStatus is the outcome of a diagnostic check.
+type CheckStatus string
+
+const (
+	CheckPass CheckStatus = "pass"
+	CheckWarn CheckStatus = "warn"
+	CheckFail CheckStatus = "fail"
+	// CheckSkip marks the checks not run because an earlier one failed.
+	CheckSkip CheckStatus = "skip"
+)
+
+// Check is the outcome of one of the checks run by Diagnose.
+type Check struct {
+	Name   string      `json:"name"`
+	Status CheckStatus `json:"status"`
+	Detail string      `json:"detail,omitempty"`
+	// Hint suggests how to fix a failure or a warning.
+	Hint string `json:"hint,omitempty"`
+}
+
+const (
+	checkProfile      = "profile"
+	checkConnectivity = "connectivity"
+	checkSealStatus   = "seal status"
+	checkAuth         = "authentication"
+	checkToken        = "token"
+	checkAlias        = "key alias"
+	checkCapabilities = "capabilities"
+	checkTransitKey   = "transit key"
+	checkChain        = "certificate chain"
+	checkKeyMatch     = "key and certificate"
+
+	// tokenTTLWarning is the token TTL below which signing may fail midway.
+	tokenTTLWarning = 10 * time.Minute
+)
+
+// checkOrder lists the checks in the order Diagnose runs them.
+var checkOrder = []string{checkProfile, checkConnectivity, checkSealStatus, checkAuth, checkToken, checkAlias, checkCapabilities, checkTransitKey, checkChain, checkKeyMatch}
+
+// Diagnose runs the checks of signing with the key ID, the same way the
+// plugin resolves and uses it, and returns their outcomes in order. The
+// checks depending on a failed one are reported as skipped.
+func Diagnose(ctx context.Context, config *Config, profileName string, id string) []*Check {
+	target := Target{Key: id, CertPath: id}
+	if alias, ok := config.alias(id); ok {
+		target = alias.target(id)
+		if alias.Profile != "" {
+			profileName = alias.Profile
+		}
+	}
+	d := &diagnosis{}
+	d.run(ctx, config, profileName, id, target, true)
+
+	last := -1
+	if len(d.checks) > 0 {
+		for i, name := range checkOrder {
+			if name == d.checks[len(d.checks)-1].Name {
+				last = i
+			}
+		}
+	}
+	for _, name := range checkOrder[last+1:] {
+		if name == checkAlias {
+			continue
+		}
+		d.checks = append(d.checks, &Check{Name: name, Status: CheckSkip, Detail: "skipped after an earlier failure"})
+	}
+	return d.checks
+}
+
+type diagnosis struct {
+	checks []*Check
+}
+
+func (d *diagnosis) add(name string, status CheckStatus, detail string, hint string) {
+	d.checks = append(d.checks, &Check{Name: name, Status: status, Detail: detail, Hint: hint})
+}
+
+// run runs the checks against the profile. resolveAlias looks the key ID up
+// in the alias table of the profile, which may point to another profile.
+func (d *diagnosis) run(ctx context.Context, config *Config, profileName string, id string, target Target, resolveAlias bool) {
+	profile, err := config.SelectProfile(profileName)
+	if err != nil {
+		d.add(checkProfile, CheckFail, err.Error(), "check the config file and the --config and --profile flags, or VAULT_ADDR and VAULT_TOKEN")
+		return
+	}
+	d.add(checkProfile, CheckPass, fmt.Sprintf("%s at %s", profile.Name, profile.Address), "")
+
+	client, err := newClient(profile)
+	if err != nil {
+		d.add(checkConnectivity, CheckFail, err.Error(), "check the tls settings of the profile")
+		return
+	}
+	resp, err := client.Read(ctx, "sys/seal-status")
+	if err != nil {
+		d.add(checkConnectivity, CheckFail, err.Error(), "check the address and tls settings of the profile and that Vault is reachable from this host")
+		return
+	}
+	var sealStatus map[string]interface{}
+	if resp != nil {
+		sealStatus = resp.Data
+	}
+	version, _ := sealStatus["version"].(string)
+	d.add(checkConnectivity, CheckPass, "Vault "+version, "")
+	if sealed, _ := sealStatus["sealed"].(bool); sealed {
+		d.add(checkSealStatus, CheckFail, "Vault is sealed", "unseal it with: vault operator unseal")
+		return
+	}
+	d.add(checkSealStatus, CheckPass, "unsealed", "")
+
+	token, err := login(ctx, client, profile.Auth)
+	if err == nil {
+		err = client.SetToken(token)
+	}
+	if err != nil {
+		d.add(checkAuth, CheckFail, err.Error(), authHint(profile.Auth))
+		return
+	}
+	method := AuthMethodToken
+	if profile.Auth != nil && profile.Auth.Method != "" {
+		method = profile.Auth.Method
+	}
+	d.add(checkAuth, CheckPass, "logged in with "+method, "")
+	d.checkToken(ctx, client)
+
+	if resolveAlias && target.Alias == "" && profile.AliasPath != "" {
+		alias, err := readKVAlias(ctx, client, profile, id)
+		if err != nil {
+			d.add(checkAlias, CheckFail, err.Error(), fmt.Sprintf("grant read on %s", profile.kvPath("data", profile.AliasPath)))
+			return
+		}
+		if alias != nil {
+			target = alias.target(id)
+			target.Profile = profile.Name
+			if alias.Profile != "" {
+				target.Profile = alias.Profile
+			}
+			d.add(checkAlias, CheckPass, fmt.Sprintf("%q is %s", id, target), "")
+			if alias.Profile != "" && alias.Profile != profile.Name {
+				d.run(ctx, config, alias.Profile, id, target, false)
+				return
+			}
+		}
+	}
+
+	d.checkCapabilities(ctx, client, profile, target)
+	key := d.checkTransitKey(ctx, client, profile, target)
+	chain := d.checkChain(ctx, client, profile, target)
+	if key == nil || chain == nil {
+		d.add(checkKeyMatch, CheckSkip, "skipped after an earlier failure", "")
+		return
+	}
+	d.checkKeyMatch(key, chain, target)
+}
+
+func authHint(auth *AuthConfig) string {
+	method := ""
+	if auth != nil {
+		method = auth.Method
+	}
+	switch method {
+	case AuthMethodAppRole:
+		return "check the role ID, the secret ID and its TTL, and the mount of the approle auth method"
+	case AuthMethodKubernetes:
+		return "check the role, the service account token and the mount of the kubernetes auth method"
+	default:
+		return "set a token with VAULT_TOKEN, or the token or tokenFile of the profile"
+	}
+}
+
+func (d *diagnosis) checkToken(ctx context.Context, client *vault.Client) {
+	resp, err := client.Auth.TokenReadLookupSelf(ctx)
+	if err != nil {
+		d.add(checkToken, CheckFail, fmt.Sprintf("failed to look up token, %v", err), "log in again, the token may be expired or revoked")
+		return
+	}
+	policies := fmt.Sprintf("policies %v", resp.Data["policies"])
+	ttl := time.Duration(toInt(resp.Data["ttl"])) * time.Second
+	switch {
+	case ttl <= 0:
+		d.add(checkToken, CheckPass, policies+", never expires", "")
+	case ttl < tokenTTLWarning:
+		d.add(checkToken, CheckWarn, fmt.Sprintf("%s, expires in %v", policies, ttl), "renew it with: vault token renew, or log in again")
+	default:
+		d.add(checkToken, CheckPass, fmt.Sprintf("%s, expires in %v", policies, ttl), "")
+	}
+}
+
+func (d *diagnosis) checkCapabilities(ctx context.Context, client *vault.Client, profile *Profile, target Target) {
+	type capability struct {
+		path, capability string
+		// optional capabilities only make signing slower when missing
+		optional bool
+	}
+	wanted := []capability{
+		{path: profile.TransitMount + "/sign/" + target.Key, capability: "update"},
+		{path: profile.kvPath("data", target.CertPath), capability: "read"},
+	}
+	if profile.KVVersion == 2 && (profile.ChainCache == nil || !profile.ChainCache.Disabled) {
+		// the chain cache revalidates its entries with a metadata read
+		wanted = append(wanted, capability{path: profile.kvPath("metadata", target.CertPath), capability: "read", optional: true})
+	}
+	paths := make([]string, len(wanted))
+	for i, w := range wanted {
+		paths[i] = w.path
+	}
+	resp, err := client.Write(ctx, "sys/capabilities-self", map[string]interface{}{"paths": paths})
+	if err != nil || resp == nil {
+		d.add(checkCapabilities, CheckWarn, fmt.Sprintf("failed to look up the capabilities of the token, %v", err), "allow update on sys/capabilities-self, as Vault's default policy does")
+		return
+	}
+	var missing, degraded, granted, policy []string
+	for _, w := range wanted {
+		capabilities, _ := resp.Data[w.path].([]interface{})
+		ok := false
+		for _, c := range capabilities {
+			if c == w.capability || c == "root" {
+				ok = true
+			}
+		}
+		if ok {
+			granted = append(granted, fmt.Sprintf("%s on %s", w.capability, w.path))
+			continue
+		}
+		if w.optional {
+			degraded = append(degraded, fmt.Sprintf("%s on %s", w.capability, w.path))
+		} else {
+			missing = append(missing, fmt.Sprintf("%s on %s", w.capability, w.path))
+		}
+		policy = append(policy, fmt.Sprintf("path %q { capabilities = [%q] }", w.path, w.capability))
+	}
+	if len(missing) > 0 {
+		d.add(checkCapabilities, CheckFail, "the token lacks "+strings.Join(append(missing, degraded...), " and "), "add to a policy of the token: "+strings.Join(policy, " "))
+		return
+	}
+	if len(degraded) > 0 {
+		d.add(checkCapabilities, CheckWarn, "the token lacks "+strings.Join(degraded, " and ")+", cached certificate chains are read again every minute instead of being revalidated", "add to a policy of the token: "+strings.Join(policy, " "))
+		return
+	}
+	d.add(checkCapabilities, CheckPass, strings.Join(granted, ", "), "")
+}
+
+func (d *diagnosis) checkTransitKey(ctx context.Context, client *vault.Client, profile *Profile, target Target) *TransitKey {
+	key, err := ReadTransitKey(ctx, client, profile, target.Key)
+	switch {
+	case errors.Is(err, ErrTransitKeyNotFound):
+		d.add(checkTransitKey, CheckFail, err.Error(), "create it with key generate or key import, or check the transitMount of the profile")
+		return nil
+	case err != nil:
+		d.add(checkTransitKey, CheckWarn, err.Error(), fmt.Sprintf("grant read on %s/keys/%s to check the key", profile.TransitMount, target.Key))
+		return nil
+	case !isSupportedKeyType(key.Type):
+		d.add(checkTransitKey, CheckFail, fmt.Sprintf("%s/keys/%s is a %s key", profile.TransitMount, target.Key, key.Type), "sign with a key of type "+strings.Join(TransitKeyTypes, ", "))
+		return nil
+	case target.Version != 0 && key.PublicKeys[target.Version] == nil:
+		d.add(checkTransitKey, CheckFail, fmt.Sprintf("%s/keys/%s has no version %d", profile.TransitMount, target.Key, target.Version), "fix the version of the key alias")
+		return nil
+	}
+	d.add(checkTransitKey, CheckPass, fmt.Sprintf("%s/keys/%s, %s, latest version %d", profile.TransitMount, target.Key, key.Type, key.LatestVersion), "")
+	return key
+}
+
+func (d *diagnosis) checkChain(ctx context.Context, client *vault.Client, profile *Profile, target Target) *CertificateChain {
+	chain, err := ReadCertificateChain(ctx, client, profile, target.CertPath)
+	if errors.Is(err, ErrSecretNotFound) {
+		d.add(checkChain, CheckFail, err.Error(), fmt.Sprintf("store the chain with: notation-hc-vault cert attach %s --cert_path <chain>", target.Key))
+		return nil
+	}
+	if err != nil {
+		d.add(checkChain, CheckFail, err.Error(), "store the chain as PEM certificates, leaf first, in the field configured by the profile")
+		return nil
+	}
+	now := time.Now()
+	if err := crypto.ValidateSigningCertificates(nil, chain.Certificates, now); err != nil {
+		d.add(checkChain, CheckFail, err.Error(), "store a chain, leaf first and ending with its root, whose leaf has the digitalSignature key usage and the codeSigning extended key usage and is valid now")
+		return nil
+	}
+	leaf := chain.Certificates[0]
+	detail := fmt.Sprintf("%d certificates at %s, leaf %q valid until %s", len(chain.Certificates), profile.KVLocation(target.CertPath), leaf.Subject, leaf.NotAfter.Format(time.RFC3339))
+	if warning := ExpiryWarning(chain.Certificates, profile.ExpiryWarningWindow(), now); warning != "" {
+		d.add(checkChain, CheckWarn, detail+", "+warning, fmt.Sprintf("renew the certificate, e.g. with: notation-hc-vault key rotate %s", target.Key))
+	} else {
+		d.add(checkChain, CheckPass, detail, "")
+	}
+	return chain
+}
+
+func (d *diagnosis) checkKeyMatch(key *TransitKey, chain *CertificateChain, target Target) {
+	// the version signing uses, as the plugin selects it
+	version := target.Version
+	if version == 0 {
+		version = chain.KeyVersion
+	}
+	if version == 0 {
+		version = key.LatestVersion
+	}
+	leaf := chain.Certificates[0]
+	certified := CertifiedVersion(key, leaf)
+	switch {
+	case certified == version:
+		d.add(checkKeyMatch, CheckPass, fmt.Sprintf("signing uses version %d, certified by the leaf", version), "")
+	case certified == 0:
+		d.add(checkKeyMatch, CheckFail, fmt.Sprintf("the leaf %q certifies no version of the key", leaf.Subject), fmt.Sprintf("store the chain issued for this key with: notation-hc-vault cert attach %s --cert_path <chain>", target.Key))
+	default:
+		d.add(checkKeyMatch, CheckFail, fmt.Sprintf("signing uses version %d but the leaf certifies version %d", version, certified), fmt.Sprintf("pair the chain with its version with: notation-hc-vault cert attach %s --cert_path <chain>, or fix the version of the key alias", target.Key))
+	}
+}
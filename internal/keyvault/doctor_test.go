@@ -0,0 +1,80 @@
+package keyvault
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	chain := testChain()
+	leafKey := publicKeyPEM(t, chain[0].PublicKey)
+	otherKey := publicKeyPEM(t, chain[1].PublicKey)
+	responses := map[string]any{
+		"/v1/sys/seal-status":          map[string]any{"sealed": false, "version": "1.13.0"},
+		"/v1/auth/token/lookup-self":   map[string]any{"policies": []string{"signer"}, "ttl": 3600},
+		"/v1/sys/capabilities-self":    map[string]any{"transit/sign/signing": []string{"update"}, "secret/data/signing": []string{"read"}, "secret/metadata/signing": []string{"read"}},
+		"/v1/transit/keys/signing":     map[string]any{"type": "rsa-2048", "latest_version": 2, "keys": map[string]any{"1": map[string]any{"public_key": leafKey}, "2": map[string]any{"public_key": otherKey}}},
+		"/v1/secret/data/signing":      map[string]any{"data": map[string]any{"certificate": encodePEM(chain), keyVersionField: 1}},
+		"/v1/transit/keys/unpaired":    map[string]any{"type": "rsa-2048", "latest_version": 2, "keys": map[string]any{"1": map[string]any{"public_key": leafKey}, "2": map[string]any{"public_key": otherKey}}},
+		"/v1/secret/data/unpaired":     map[string]any{"data": map[string]any{"certificate": encodePEM(chain)}},
+		"/v1/transit/keys/symmetric":   map[string]any{"type": "aes256-gcm96", "latest_version": 1},
+		"/v1/secret/data/root-as-leaf": map[string]any{"data": map[string]any{"certificate": encodePEM(chain[1:])}},
+	}
+	server := newFakeVault(t, responses)
+	config := &Config{Profiles: map[string]*Profile{"dev": {Address: server.URL, Auth: &AuthConfig{Token: "root"}}}}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	statuses := func(id string) map[string]*Check {
+		checks := Diagnose(context.Background(), config, "", id)
+		if len(checks) != len(checkOrder)-1 {
+			t.Errorf("%s: expected every check but the key alias to be reported, got %d", id, len(checks))
+		}
+		byName := make(map[string]*Check)
+		for _, check := range checks {
+			byName[check.Name] = check
+		}
+		return byName
+	}
+
+	// the test leaf certificate expires within a day, which is a warning
+	checks := statuses("signing")
+	for name, check := range checks {
+		if check.Status != CheckPass && (name != checkChain || check.Status != CheckWarn) {
+			t.Errorf("signing: expected %s to pass, got %+v", name, check)
+		}
+	}
+
+	// the chain is not paired, so signing uses the latest version
+	checks = statuses("unpaired")
+	if check := checks[checkKeyMatch]; check.Status != CheckFail || !strings.Contains(check.Detail, "leaf certifies version 1") {
+		t.Errorf("unpaired: unexpected key match check %+v", check)
+	}
+	if check := checks[checkCapabilities]; check.Status != CheckFail || !strings.Contains(check.Hint, `path "transit/sign/unpaired"`) {
+		t.Errorf("unpaired: unexpected capabilities check %+v", check)
+	}
+
+	// without the metadata read, signing still works but the chain cache
+	// cannot revalidate its entries
+	capabilities := responses["/v1/sys/capabilities-self"].(map[string]any)
+	delete(capabilities, "secret/metadata/signing")
+	if check := statuses("signing")[checkCapabilities]; check.Status != CheckWarn || !strings.Contains(check.Hint, `path "secret/metadata/signing"`) {
+		t.Errorf("signing: unexpected capabilities check without metadata read %+v", check)
+	}
+	capabilities["secret/metadata/signing"] = []string{"read"}
+
+	checks = statuses("symmetric")
+	if checks[checkTransitKey].Status != CheckFail || checks[checkChain].Status != CheckFail || checks[checkKeyMatch].Status != CheckSkip {
+		t.Errorf("symmetric: unexpected checks %+v %+v %+v", checks[checkTransitKey], checks[checkChain], checks[checkKeyMatch])
+	}
+	if check := statuses("root-as-leaf")[checkChain]; check.Status != CheckFail || check.Hint == "" {
+		t.Errorf("root-as-leaf: unexpected chain check %+v", check)
+	}
+
+	responses["/v1/sys/seal-status"] = map[string]any{"sealed": true}
+	checks = statuses("signing")
+	if checks[checkSealStatus].Status != CheckFail || checks[checkAuth].Status != CheckSkip || checks[checkKeyMatch].Status != CheckSkip {
+		t.Errorf("expected the checks after a sealed Vault to be skipped, got %+v %+v", checks[checkSealStatus], checks[checkAuth])
+	}
+}
@@ -0,0 +1,92 @@
+package keyvault
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultExpiryWarning is the certificate lifetime left below which renewal
+// is due.
+const defaultExpiryWarning = 30 * 24 * time.Hour
+
+// ParseDuration parses a Go duration such as 12h, or a number of days such
+// as 30d.
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// ExpiryWarningWindow returns the lifetime left below which the certificates
+// of the profile are reported as expiring, 30 days unless configured.
+func (p *Profile) ExpiryWarningWindow() time.Duration {
+	if p.ExpiryWarning == "" {
+		return defaultExpiryWarning
+	}
+	return p.expiryWarning
+}
+
+// FirstExpiring returns the certificate of the chain that expires first.
+func FirstExpiring(certs []*x509.Certificate) *x509.Certificate {
+	var first *x509.Certificate
+	for _, cert := range certs {
+		if first == nil || cert.NotAfter.Before(first.NotAfter) {
+			first = cert
+		}
+	}
+	return first
+}
+
+// ExpiryWarning returns a warning if a certificate of the chain expires
+// within window from now or has already expired, or an empty string.
+func ExpiryWarning(certs []*x509.Certificate, window time.Duration, now time.Time) string {
+	cert := FirstExpiring(certs)
+	if cert == nil || cert.NotAfter.Sub(now) >= window {
+		return ""
+	}
+	if !now.Before(cert.NotAfter) {
+		return fmt.Sprintf("certificate %q expired on %s", cert.Subject, cert.NotAfter.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("certificate %q expires on %s, in %s", cert.Subject, cert.NotAfter.Format(time.RFC3339), FormatRemaining(cert.NotAfter.Sub(now)))
+}
+
+// FormatRemaining formats the lifetime left of a certificate in days, or in
+// hours under two days.
+func FormatRemaining(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "expired"
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
+
+// ExpiringKeys returns the keys whose certificate chain expires before
+// deadline, soonest first. Keys without a chain are left out.
+func ExpiringKeys(infos []*KeyInfo, deadline time.Time) []*KeyInfo {
+	var expiring []*KeyInfo
+	for _, info := range infos {
+		if info.ChainNotAfter != nil && info.ChainNotAfter.Before(deadline) {
+			expiring = append(expiring, info)
+		}
+	}
+	sort.SliceStable(expiring, func(i, j int) bool {
+		return expiring[i].ChainNotAfter.Before(*expiring[j].ChainNotAfter)
+	})
+	return expiring
+}
@@ -0,0 +1,82 @@
+package keyvault
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	for s, want := range map[string]time.Duration{"30d": 30 * 24 * time.Hour, "0d": 0, "72h": 72 * time.Hour, "90m": 90 * time.Minute} {
+		if got, err := ParseDuration(s); err != nil || got != want {
+			t.Errorf("%s: expected %v, got %v, %v", s, want, got, err)
+		}
+	}
+	for _, s := range []string{"", "d", "1.5d", "30days", "soon"} {
+		if _, err := ParseDuration(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestExpiryWarning(t *testing.T) {
+	chain := testChain()
+	leaf := chain[0]
+
+	profile := &Profile{Name: "dev", Address: "http://127.0.0.1:8200"}
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if window := profile.ExpiryWarningWindow(); window != 30*24*time.Hour {
+		t.Fatalf("expected a 30 days window by default, got %v", window)
+	}
+	if warning := ExpiryWarning(chain, profile.ExpiryWarningWindow(), time.Now()); !strings.Contains(warning, "expires on") {
+		t.Errorf("expected the test leaf, valid for a day, to expire within the window, got %q", warning)
+	}
+
+	// the test leaf expires first, well within the window of a week before
+	// its expiry, and outside a window of an hour
+	now := leaf.NotAfter.Add(-3 * 24 * time.Hour)
+	if FirstExpiring(chain) != leaf {
+		t.Fatal("expected the leaf to expire first")
+	}
+	if warning := ExpiryWarning(chain, 7*24*time.Hour, now); !strings.Contains(warning, "in 3d") {
+		t.Errorf("expected a warning 3 days before expiry, got %q", warning)
+	}
+	if warning := ExpiryWarning(chain, time.Hour, now); warning != "" {
+		t.Errorf("expected no warning, got %q", warning)
+	}
+	want := fmt.Sprintf("certificate %q expired on %s", leaf.Subject, leaf.NotAfter.Format(time.RFC3339))
+	if warning := ExpiryWarning(chain, time.Hour, leaf.NotAfter.Add(time.Hour)); warning != want {
+		t.Errorf("expected %q for an expired certificate, got %q", want, warning)
+	}
+
+	profile.ExpiryWarning = "1w"
+	if err := profile.Validate(); err == nil || !strings.Contains(err.Error(), "invalid expiryWarning") {
+		t.Errorf("expected an invalid expiryWarning error, got %v", err)
+	}
+}
+
+func TestExpiringKeys(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	infos := []*KeyInfo{
+		{Key: "later", ChainNotAfter: at(20 * 24 * time.Hour)},
+		{Key: "nocert", CertError: "not found"},
+		{Key: "fine", ChainNotAfter: at(365 * 24 * time.Hour)},
+		{Key: "expired", ChainNotAfter: at(-time.Hour)},
+		{Key: "soon", ChainNotAfter: at(time.Hour)},
+	}
+	expiring := ExpiringKeys(infos, now.Add(30*24*time.Hour))
+	var keys []string
+	for _, info := range expiring {
+		keys = append(keys, info.Key)
+	}
+	if got := strings.Join(keys, ","); got != "expired,soon,later" {
+		t.Errorf("expected expired,soon,later, got %s", got)
+	}
+}
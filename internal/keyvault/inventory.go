@@ -0,0 +1,113 @@
+package keyvault
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"github.com/hashicorp/vault-client-go"
+	"time"
+)
+
+// KeyInfo summarizes a transit key and the certificate chain stored for it.
+type KeyInfo struct {
+	Profile       string `json:"profile"`
+	Key           string `json:"key"`
+	Type          string `json:"type"`
+	LatestVersion int    `json:"latestVersion"`
+	Exportable    bool   `json:"exportable"`
+	// KeyError reports why the transit key could not be read, for example
+	// for lack of permission on that key.
+	KeyError string `json:"keyError,omitempty"`
+
+	CertPath string     `json:"certPath"`
+	Subject  string     `json:"subject,omitempty"`
+	Issuer   string     `json:"issuer,omitempty"`
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+	// ChainNotAfter is the expiry of the certificate of the chain that
+	// expires first, which may be an intermediate.
+	ChainNotAfter *time.Time `json:"chainNotAfter,omitempty"`
+	// CertError reports why no certificate could be read for the key.
+	CertError string `json:"certError,omitempty"`
+
+	// CertKeyVersion is the key version whose public key is certified by
+	// the leaf certificate, 0 if none is.
+	CertKeyVersion int `json:"certKeyVersion,omitempty"`
+	// SigningVersion is the key version used for signing, the version the
+	// chain is paired with or else the latest.
+	SigningVersion int `json:"signingVersion"`
+	// Match reports whether the leaf certificate certifies the signing
+	// version.
+	Match bool `json:"match"`
+}
+
+// Inventory lists the transit keys of the profile along with the
+// certificate chains stored at the KV paths of the same names. A key or
+// chain that cannot be read is reported on its KeyInfo rather than failing
+// the listing.
+func Inventory(ctx context.Context, client *vault.Client, profile *Profile) ([]*KeyInfo, error) {
+	names, err := ListTransitKeys(ctx, client, profile)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*KeyInfo, 0, len(names))
+	for _, name := range names {
+		info := &KeyInfo{
+			Profile:  profile.Name,
+			Key:      name,
+			CertPath: profile.KVLocation(name),
+		}
+		key, err := ReadTransitKey(ctx, client, profile, name)
+		if err != nil {
+			key = nil
+			info.KeyError = err.Error()
+		} else {
+			info.Type = key.Type
+			info.LatestVersion = key.LatestVersion
+			info.Exportable = key.Exportable
+		}
+		chain, err := ReadCertificateChain(ctx, client, profile, name)
+		if err != nil {
+			info.CertError = err.Error()
+		} else {
+			leaf := chain.Certificates[0]
+			info.Subject = leaf.Subject.String()
+			info.Issuer = leaf.Issuer.String()
+			info.NotAfter = &leaf.NotAfter
+			info.ChainNotAfter = &FirstExpiring(chain.Certificates).NotAfter
+			info.SigningVersion = chain.KeyVersion
+			if key != nil {
+				info.CertKeyVersion = CertifiedVersion(key, leaf)
+				if info.SigningVersion == 0 {
+					info.SigningVersion = key.LatestVersion
+				}
+				info.Match = info.CertKeyVersion != 0 && info.CertKeyVersion == info.SigningVersion
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// CertifiedVersion returns the key version whose public key is the one of
+// the certificate, 0 if there is none.
+func CertifiedVersion(key *TransitKey, cert *x509.Certificate) int {
+	return keyVersionOf(key, cert.PublicKey)
+}
+
+// keyVersionOf returns the key version whose public key is pub, 0 if there
+// is none.
+func keyVersionOf(key *TransitKey, pub crypto.PublicKey) int {
+	if signer, ok := pub.(crypto.Signer); ok {
+		pub = signer.Public()
+	}
+	publicKey, ok := pub.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return 0
+	}
+	for _, version := range key.Versions() {
+		if publicKey.Equal(key.PublicKeys[version]) {
+			return version
+		}
+	}
+	return 0
+}
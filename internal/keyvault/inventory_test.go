@@ -0,0 +1,76 @@
+package keyvault
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"strconv"
+	"testing"
+)
+
+func publicKeyPEM(t *testing.T, pub crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestInventory(t *testing.T) {
+	chain := testChain()
+	leafKey := publicKeyPEM(t, chain[0].PublicKey)
+	otherKey := publicKeyPEM(t, chain[1].PublicKey)
+	transitKey := func(versions ...string) map[string]any {
+		keys := map[string]any{}
+		for i, pub := range versions {
+			keys[strconv.Itoa(i+1)] = map[string]any{"public_key": pub, "creation_time": "2023-05-01T00:00:00Z"}
+		}
+		return map[string]any{"type": "rsa-2048", "latest_version": len(versions), "keys": keys}
+	}
+	certificate := map[string]any{"data": map[string]any{"certificate": encodePEM(chain)}}
+	server := newFakeVault(t, map[string]any{
+		"/v1/transit/keys":         map[string]any{"keys": []string{"stale", "signing", "nocert", "denied"}},
+		"/v1/transit/keys/signing": transitKey(otherKey, leafKey),
+		"/v1/transit/keys/stale":   transitKey(leafKey, otherKey),
+		"/v1/transit/keys/nocert":  transitKey(leafKey),
+		"/v1/secret/data/signing":  certificate,
+		"/v1/secret/data/stale":    certificate,
+		"/v1/secret/data/denied":   certificate,
+	})
+	profile := &Profile{Name: "dev", Address: server.URL, Auth: &AuthConfig{Token: "root"}}
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(context.Background(), profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := Inventory(context.Background(), client, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 4 {
+		t.Fatalf("expected 4 keys, got %d", len(infos))
+	}
+	byKey := map[string]*KeyInfo{}
+	for _, info := range infos {
+		byKey[info.Key] = info
+	}
+
+	if info := byKey["signing"]; !info.Match || info.CertKeyVersion != 2 || info.Subject != chain[0].Subject.String() || info.NotAfter == nil {
+		t.Errorf("expected signing to match its certificate, got %+v", info)
+	}
+	if info := byKey["stale"]; info.Match || info.CertKeyVersion != 1 {
+		t.Errorf("expected stale to certify version 1 only, got %+v", info)
+	}
+	if info := byKey["nocert"]; info.Match || info.CertError == "" {
+		t.Errorf("expected nocert to report a certificate error, got %+v", info)
+	}
+	// a key that cannot be read is reported, with its certificate
+	if info := byKey["denied"]; info.KeyError == "" || info.Match || info.Subject != chain[0].Subject.String() {
+		t.Errorf("expected denied to report a key error, got %+v", info)
+	}
+}
@@ -3,90 +3,151 @@ package keyvault
 import (
 	"context"
 	"crypto/x509"
-	"encoding/base64"
+	"fmt"
 	"github.com/OliverShang/notation-hc-vault/internal/crypto"
 	"github.com/hashicorp/vault-client-go"
-	"github.com/hashicorp/vault-client-go/schema"
-	"log"
-	"os"
-	"strings"
-	"time"
 )
 
-var VAULTADDR, VAULTTOKEN string
-
 var ParseCertificates = crypto.ParseCertificates
 
 type VaultClientWrapper struct {
 	vaultClient *vault.Client
+	profile     *Profile
 
-	keyID string
+	keyID  string
+	target Target
 }
 
-func NewVaultClientFromKeyID(id string) (*VaultClientWrapper, error) {
-	// read addr and token from environment variables
-	VAULTADDR = os.Getenv("VAULT_ADDR")
-	if len(VAULTADDR) < 1 {
-		log.Fatal("Error loading vault address")
+// NewVaultClientFromKeyID returns a client for the key, connected to the Vault
+// profile selected by the plugin config or the environment.
+func NewVaultClientFromKeyID(ctx context.Context, id string, pluginConfig map[string]string) (*VaultClientWrapper, error) {
+	config, err := ResolveConfig(pluginConfig[PluginConfigFile])
+	if err != nil {
+		return nil, err
 	}
+	return NewVaultClient(ctx, config, selectedProfile(pluginConfig), id)
+}
 
-	VAULTTOKEN = os.Getenv("VAULT_TOKEN")
-	if len(VAULTTOKEN) < 1 {
-		log.Fatal("Error loading vault token")
+// NewVaultClient returns a client for the key ID. The key ID is resolved
+// through the aliases of the config file and of the profile's alias table
+// before falling back to a transit key and KV path of the same name.
+func NewVaultClient(ctx context.Context, config *Config, profileName string, id string) (*VaultClientWrapper, error) {
+	target := Target{Key: id, CertPath: id}
+	if alias, ok := config.alias(id); ok {
+		target = alias.target(id)
+		if alias.Profile != "" {
+			profileName = alias.Profile
+		}
 	}
-
-	// prepare a client with the given base address
-	client, err := vault.New(
-		vault.WithAddress(VAULTADDR),
-		vault.WithRequestTimeout(30*time.Second),
-	)
+	profile, err := config.SelectProfile(profileName)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewClient(ctx, profile)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	// authenticate with a root token (insecure)
-	if err := client.SetToken(VAULTTOKEN); err != nil {
-		log.Fatal(err)
+	if target.Alias == "" && profile.AliasPath != "" {
+		alias, err := readKVAlias(ctx, client, profile, id)
+		if err != nil {
+			return nil, err
+		}
+		if alias != nil {
+			target = alias.target(id)
+			if alias.Profile != "" && alias.Profile != profile.Name {
+				if profile, err = config.SelectProfile(alias.Profile); err != nil {
+					return nil, fmt.Errorf("alias %q: %v", id, err)
+				}
+				if client, err = NewClient(ctx, profile); err != nil {
+					return nil, err
+				}
+			}
+		}
 	}
+	target.Profile = profile.Name
 
 	return &VaultClientWrapper{
 		vaultClient: client,
+		profile:     profile,
 		keyID:       id,
+		target:      target,
 	}, nil
 }
 
+// Target returns the Vault location the key ID resolved to.
+func (vw *VaultClientWrapper) Target() Target {
+	return vw.target
+}
+
+// Profile returns the Vault profile the key ID resolved to.
+func (vw *VaultClientWrapper) Profile() *Profile {
+	return vw.profile
+}
+
+// GetCertificateChain returns the certificate chain of the key, leaf first,
+// from the local cache if it is still current.
 func (vw *VaultClientWrapper) GetCertificateChain(ctx context.Context) ([]*x509.Certificate, error) {
-	// read a certChain
-	secret, err := vw.vaultClient.Secrets.KVv2Read(ctx, vw.keyID)
+	chain, err := vw.CertificateChain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return chain.Certificates, nil
+}
+
+// CertificateChain returns the certificate chain of the key along with the
+// key version it is paired with, from the local cache if it is still current.
+func (vw *VaultClientWrapper) CertificateChain(ctx context.Context) (*CertificateChain, error) {
+	cache, err := newChainCache(vw.profile, vw.target.CertPath)
+	if err != nil || cache == nil {
+		return vw.readCertificateChain(ctx)
+	}
+	return vw.cachedCertificateChain(ctx, cache)
+}
+
+func (vw *VaultClientWrapper) readCertificateChain(ctx context.Context) (*CertificateChain, error) {
+	secret, err := ReadKV(ctx, vw.vaultClient, vw.profile, vw.target.CertPath)
 	if err != nil {
 		return nil, err
 	}
-	//fmt.Println("Successfully got the cert chain from vault")
-	certString := secret.Data["data"].(map[string]interface{})["certificate"].(string)
-	certBytes := []byte(certString)
-	certs, err := ParseCertificates(certBytes)
-	return certs, nil
+	chain, err := vw.profile.certificateChainFromSecret(secret, vw.target.CertPath)
+	if err != nil || vw.profile.ChainCompletion == nil {
+		return chain, err
+	}
+	return vw.completeCertificateChain(ctx, chain, secret)
 }
 
-func (vw *VaultClientWrapper) SignWithTransit(ctx context.Context, encodedData string, signAlgorithm string) ([]byte, error) {
-	// sign with transit SE
-	resp, err := vw.vaultClient.Secrets.TransitSign(ctx, vw.keyID, schema.TransitSignRequest{
-		Input:               encodedData,
-		MarshalingAlgorithm: "asn1",
-		KeyVersion:          0,
-		Prehashed:           true,
-		SaltLength:          "hash",
-		SignatureAlgorithm:  signAlgorithm,
-	})
+// ReadCertificateChain reads the certificate chain stored at path in the
+// profile's KV mount.
+func ReadCertificateChain(ctx context.Context, client *vault.Client, profile *Profile, path string) (*CertificateChain, error) {
+	secret, err := ReadKV(ctx, client, profile, path)
 	if err != nil {
 		return nil, err
 	}
+	return profile.certificateChainFromSecret(secret, path)
+}
 
-	signature := resp.Data["signature"].(string)
-	items := strings.Split(signature, ":")
-	sigBytes, err := base64.StdEncoding.DecodeString(items[2])
+func (p *Profile) certificateChainFromSecret(secret *KVSecret, path string) (*CertificateChain, error) {
+	certs, err := p.certificatesFromSecret(secret.Data, p.KVLocation(path))
 	if err != nil {
 		return nil, err
 	}
-	return sigBytes, nil
+	return &CertificateChain{
+		Certificates: certs,
+		KeyVersion:   toInt(secret.Data[keyVersionField]),
+		Version:      secret.Version,
+	}, nil
+}
+
+// SignWithTransit signs a base64 encoded digest with the key version the key
+// ID resolved to, the latest version unless an alias pins one. It returns the
+// ASN.1 encoded signature and the key version that produced it.
+func (vw *VaultClientWrapper) SignWithTransit(ctx context.Context, encodedData string, signAlgorithm string, hashAlgorithm string) ([]byte, int, error) {
+	return vw.SignWithKeyVersion(ctx, vw.target.Version, encodedData, signAlgorithm, hashAlgorithm)
+}
+
+// SignWithKeyVersion is SignWithTransit with an explicit key version, 0
+// meaning the latest.
+func (vw *VaultClientWrapper) SignWithKeyVersion(ctx context.Context, version int, encodedData string, signAlgorithm string, hashAlgorithm string) ([]byte, int, error) {
+	return transitSign(ctx, vw.vaultClient, vw.profile, vw.target.Key, version, encodedData, signAlgorithm, hashAlgorithm)
 }
@@ -0,0 +1,120 @@
+package keyvault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault-client-go"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// KVSecret is a secret read from a KV v1 or v2 secrets engine.
+type KVSecret struct {
+	Data map[string]interface{}
+	// Version is the version of a KV v2 secret, 0 for KV v1.
+	Version int
+}
+
+// ErrSecretNotFound is returned by ReadKV for missing secrets.
+var ErrSecretNotFound = errors.New("not found")
+
+// ReadKV reads the secret at path from the profile's KV mount, honoring the
+// configured KV engine version.
+func ReadKV(ctx context.Context, client *vault.Client, profile *Profile, path string) (*KVSecret, error) {
+	resp, err := client.Read(ctx, profile.kvPath("data", path))
+	if err != nil {
+		if vault.IsErrorStatus(err, http.StatusNotFound) {
+			return nil, fmt.Errorf("secret %s %w", profile.KVLocation(path), ErrSecretNotFound)
+		}
+		return nil, fmt.Errorf("failed to read secret %s, %v", profile.KVLocation(path), err)
+	}
+	if resp == nil || resp.Data == nil {
+		return nil, fmt.Errorf("secret %s is empty", profile.KVLocation(path))
+	}
+	if profile.KVVersion == 1 {
+		return &KVSecret{Data: resp.Data}, nil
+	}
+
+	data, ok := resp.Data["data"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("secret %s has no data, is %s a KV v2 mount?", profile.KVLocation(path), profile.KVMount)
+	}
+	secret := &KVSecret{Data: data}
+	if metadata, ok := resp.Data["metadata"].(map[string]interface{}); ok {
+		secret.Version = toInt(metadata["version"])
+	}
+	return secret, nil
+}
+
+// ReadKVIfExists is ReadKV returning a nil secret for missing secrets.
+func ReadKVIfExists(ctx context.Context, client *vault.Client, profile *Profile, path string) (*KVSecret, error) {
+	secret, err := ReadKV(ctx, client, profile, path)
+	if errors.Is(err, ErrSecretNotFound) {
+		return nil, nil
+	}
+	return secret, err
+}
+
+// WriteKV writes data to path in the profile's KV mount. For KV v2, a
+// non-negative cas enables check-and-set against that version.
+func WriteKV(ctx context.Context, client *vault.Client, profile *Profile, path string, data map[string]interface{}, cas int) (int, error) {
+	body := data
+	if profile.KVVersion != 1 {
+		body = map[string]interface{}{"data": data}
+		if cas >= 0 {
+			body["options"] = map[string]interface{}{"cas": cas}
+		}
+	}
+	resp, err := client.Write(ctx, profile.kvPath("data", path), body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write secret %s, %v", profile.KVLocation(path), err)
+	}
+	if resp == nil || profile.KVVersion == 1 {
+		return 0, nil
+	}
+	return toInt(resp.Data["version"]), nil
+}
+
+// DeleteKV permanently deletes the secret at path, including all versions
+// and metadata of a KV v2 secret.
+func DeleteKV(ctx context.Context, client *vault.Client, profile *Profile, path string) error {
+	if _, err := client.Delete(ctx, profile.kvPath("metadata", path)); err != nil {
+		return fmt.Errorf("failed to delete secret %s, %v", profile.KVLocation(path), err)
+	}
+	return nil
+}
+
+// KVLocation returns a human readable location of a KV path.
+func (p *Profile) KVLocation(path string) string {
+	return p.KVMount + "/" + strings.TrimPrefix(path, "/")
+}
+
+// kvPath returns the API path of a KV path. prefix is the KV v2 sub-path,
+// such as data or metadata, and is ignored for KV v1.
+func (p *Profile) kvPath(prefix string, path string) string {
+	path = strings.TrimPrefix(path, "/")
+	if p.KVVersion == 1 {
+		return p.KVMount + "/" + path
+	}
+	return p.KVMount + "/" + prefix + "/" + path
+}
+
+func toInt(v interface{}) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case json.Number:
+		i, _ := n.Int64()
+		return int(i)
+	case int:
+		return n
+	case string:
+		i, _ := strconv.Atoi(n)
+		return i
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,220 @@
+package keyvault
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault-client-go"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Manifest lists keys to import in bulk. It is read from YAML or JSON.
+type Manifest struct {
+	// Defaults applies to the entries not setting a field themselves.
+	Defaults ManifestEntry    `json:"defaults,omitempty" yaml:"defaults,omitempty"`
+	Keys     []*ManifestEntry `json:"keys" yaml:"keys"`
+}
+
+// ManifestEntry describes one key to import. Relative file paths are
+// relative to the manifest.
+type ManifestEntry struct {
+	// Name is the name of the transit key and of the KV path of its chain.
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// KeyPath is the private key file or PKCS #12 bundle.
+	KeyPath string `json:"keyPath,omitempty" yaml:"keyPath,omitempty"`
+	// CertPath is the certificate chain file, optional for PKCS #12 bundles
+	// holding the chain.
+	CertPath string `json:"certPath,omitempty" yaml:"certPath,omitempty"`
+	// PassphraseFile holds the passphrase of an encrypted KeyPath.
+	PassphraseFile string `json:"passphraseFile,omitempty" yaml:"passphraseFile,omitempty"`
+	// Type is the expected transit key type, checked against the key.
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// TransitMount and KVMount override the mounts of the profile.
+	TransitMount string `json:"transitMount,omitempty" yaml:"transitMount,omitempty"`
+	KVMount      string `json:"kvMount,omitempty" yaml:"kvMount,omitempty"`
+	// Metadata is stored as extra fields of the KV secret of the chain.
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+// LoadManifest reads and validates the manifest at path, applying the
+// defaults to its entries and resolving their file paths.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// JSON documents are YAML documents as well
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var manifest Manifest
+	if err := decoder.Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("malformed manifest %s: %w", path, err)
+	}
+	if err := manifest.validate(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	return &manifest, nil
+}
+
+func (m *Manifest) validate(dir string) error {
+	if len(m.Keys) == 0 {
+		return errors.New("no keys listed")
+	}
+	if m.Defaults.Name != "" || m.Defaults.KeyPath != "" || m.Defaults.CertPath != "" {
+		return errors.New("defaults cannot set name, keyPath or certPath")
+	}
+	seen := make(map[string]int)
+	for i, entry := range m.Keys {
+		if entry == nil {
+			return fmt.Errorf("entry %d is empty", i+1)
+		}
+		entry.applyDefaults(&m.Defaults)
+		if err := entry.validate(dir); err != nil {
+			if entry.Name != "" {
+				return fmt.Errorf("entry %d (%s): %v", i+1, entry.Name, err)
+			}
+			return fmt.Errorf("entry %d: %v", i+1, err)
+		}
+		id := entry.TransitMount + "/" + entry.Name
+		if previous, ok := seen[id]; ok {
+			return fmt.Errorf("entry %d (%s): duplicate of entry %d", i+1, entry.Name, previous)
+		}
+		seen[id] = i + 1
+	}
+	return nil
+}
+
+func (e *ManifestEntry) applyDefaults(defaults *ManifestEntry) {
+	if e.PassphraseFile == "" {
+		e.PassphraseFile = defaults.PassphraseFile
+	}
+	if e.Type == "" {
+		e.Type = defaults.Type
+	}
+	if e.TransitMount == "" {
+		e.TransitMount = defaults.TransitMount
+	}
+	if e.KVMount == "" {
+		e.KVMount = defaults.KVMount
+	}
+	if len(defaults.Metadata) > 0 {
+		metadata := make(map[string]string, len(defaults.Metadata)+len(e.Metadata))
+		for field, value := range defaults.Metadata {
+			metadata[field] = value
+		}
+		for field, value := range e.Metadata {
+			metadata[field] = value
+		}
+		e.Metadata = metadata
+	}
+}
+
+func (e *ManifestEntry) validate(dir string) error {
+	if e.Name == "" {
+		return errors.New("name is required")
+	}
+	if strings.Contains(e.Name, "/") {
+		return fmt.Errorf("name %q must not contain '/'", e.Name)
+	}
+	if e.KeyPath == "" {
+		return errors.New("keyPath is required")
+	}
+	if e.Type != "" && !isSupportedKeyType(e.Type) {
+		return fmt.Errorf("unsupported key type %q, must be one of %s", e.Type, strings.Join(TransitKeyTypes, ", "))
+	}
+	for field, mount := range map[string]string{
+		"transitMount": e.TransitMount,
+		"kvMount":      e.KVMount,
+	} {
+		if strings.HasPrefix(mount, "/") || strings.HasSuffix(mount, "/") {
+			return fmt.Errorf("%s %q must not start or end with '/'", field, mount)
+		}
+	}
+	for _, path := range []*string{&e.KeyPath, &e.CertPath, &e.PassphraseFile} {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	return nil
+}
+
+// Profile returns the profile to import the entry with, base with the
+// mounts of the entry.
+func (e *ManifestEntry) Profile(base *Profile) *Profile {
+	profile := *base
+	if e.TransitMount != "" {
+		profile.TransitMount = e.TransitMount
+	}
+	if e.KVMount != "" {
+		profile.KVMount = e.KVMount
+	}
+	return &profile
+}
+
+// CheckMetadata checks that the metadata of the entry does not overwrite the
+// KV fields of the certificate chain of the profile.
+func (e *ManifestEntry) CheckMetadata(profile *Profile) error {
+	for field := range e.Metadata {
+		if profile.isCertificateField(field) {
+			return fmt.Errorf("metadata field %q is reserved for the certificate chain", field)
+		}
+	}
+	return nil
+}
+
+// ImportState reports how much of a key import is already done, so that an
+// interrupted import can be resumed.
+type ImportState struct {
+	// KeyExists reports whether the transit key exists.
+	KeyExists bool
+	// KeyVersion is the transit key version holding the imported key, 0 if
+	// it was not imported yet.
+	KeyVersion int
+	// ChainStored reports whether the KV secret holds the chain and metadata
+	// to import, paired with KeyVersion.
+	ChainStored bool
+	// Secret is the KV secret of the key, nil if there is none.
+	Secret *KVSecret
+}
+
+// ReadImportState compares the transit key and KV secret called name with
+// the key pair of pub, its chain certs and the metadata fields to import.
+func ReadImportState(ctx context.Context, client *vault.Client, profile *Profile, name string, pub crypto.PublicKey, certs []*x509.Certificate, fields map[string]string) (*ImportState, error) {
+	state := &ImportState{}
+	key, err := ReadTransitKey(ctx, client, profile, name)
+	if err != nil && !errors.Is(err, ErrTransitKeyNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		state.KeyExists = true
+		state.KeyVersion = keyVersionOf(key, pub)
+	}
+	if state.Secret, err = ReadKVIfExists(ctx, client, profile, name); err != nil {
+		return nil, err
+	}
+	if state.KeyVersion == 0 || state.Secret == nil || toInt(state.Secret.Data[keyVersionField]) != state.KeyVersion {
+		return state, nil
+	}
+	stored, err := profile.certificatesFromSecret(state.Secret.Data, profile.KVLocation(name))
+	if err != nil || len(stored) != len(certs) {
+		return state, nil
+	}
+	for i, cert := range stored {
+		if !cert.Equal(certs[i]) {
+			return state, nil
+		}
+	}
+	for field, value := range fields {
+		if state.Secret.Data[field] != value {
+			return state, nil
+		}
+	}
+	state.ChainStored = true
+	return state, nil
+}
@@ -0,0 +1,172 @@
+package keyvault
+
+import (
+	"context"
+	"github.com/notaryproject/notation-core-go/testhelper"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeManifest(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadManifest(t *testing.T) {
+	path := writeManifest(t, "keys.yaml", `
+defaults:
+  passphraseFile: passphrase
+  metadata:
+    migratedFrom: hsm-01
+keys:
+  - name: release
+    keyPath: keys/release.p12
+    type: ecdsa-p256
+  - name: nightly
+    keyPath: /etc/keys/nightly.key
+    certPath: chains/nightly.pem
+    transitMount: transit-ci
+    metadata:
+      owner: ci-team
+      migratedFrom: hsm-02
+`)
+	manifest, err := LoadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Dir(path)
+	release, nightly := manifest.Keys[0], manifest.Keys[1]
+	if release.KeyPath != filepath.Join(dir, "keys/release.p12") || release.PassphraseFile != filepath.Join(dir, "passphrase") || release.Metadata["migratedFrom"] != "hsm-01" {
+		t.Errorf("unexpected release entry %+v", release)
+	}
+	if nightly.KeyPath != "/etc/keys/nightly.key" || nightly.CertPath != filepath.Join(dir, "chains/nightly.pem") || nightly.Metadata["migratedFrom"] != "hsm-02" || nightly.Metadata["owner"] != "ci-team" {
+		t.Errorf("unexpected nightly entry %+v", nightly)
+	}
+	profile := &Profile{TransitMount: "transit", KVMount: "secret"}
+	if p := nightly.Profile(profile); p.TransitMount != "transit-ci" || p.KVMount != "secret" || profile.TransitMount != "transit" {
+		t.Errorf("unexpected profile of the nightly entry %+v", p)
+	}
+
+	path = writeManifest(t, "keys.json", `{"keys": [{"name": "release", "keyPath": "release.key", "certPath": "release.pem"}]}`)
+	if manifest, err = LoadManifest(path); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Keys[0].CertPath != filepath.Join(filepath.Dir(path), "release.pem") {
+		t.Errorf("unexpected entry %+v", manifest.Keys[0])
+	}
+}
+
+func TestLoadManifestInvalid(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		errMsg  string
+	}{
+		"no keys":        {`keys: []`, "no keys listed"},
+		"unknown field":  {`{"keys": [{"name": "a", "keyPath": "a.key", "keyFile": "a.key"}]}`, "keyFile"},
+		"missing name":   {`{"keys": [{"keyPath": "a.key"}]}`, "entry 1: name is required"},
+		"missing key":    {`{"keys": [{"name": "a"}]}`, "entry 1 (a): keyPath is required"},
+		"bad type":       {`{"keys": [{"name": "a", "keyPath": "a.key", "type": "ed25519"}]}`, "unsupported key type"},
+		"bad mount":      {`{"keys": [{"name": "a", "keyPath": "a.key", "kvMount": "/secret"}]}`, "must not start or end with '/'"},
+		"duplicate":      {`{"keys": [{"name": "a", "keyPath": "a.key"}, {"name": "a", "keyPath": "b.key"}]}`, "entry 2 (a): duplicate of entry 1"},
+		"default name":   {`{"defaults": {"name": "a"}, "keys": [{"name": "a", "keyPath": "a.key"}]}`, "defaults cannot set"},
+		"name with path": {`{"keys": [{"name": "a/b", "keyPath": "a.key"}]}`, "must not contain '/'"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadManifest(writeManifest(t, "keys.yaml", tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+
+	// the same name in other transit mounts is another key
+	path := writeManifest(t, "keys.yaml", `{"keys": [{"name": "a", "keyPath": "a.key"}, {"name": "a", "keyPath": "b.key", "transitMount": "transit-ci"}]}`)
+	if _, err := LoadManifest(path); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestManifestEntryCheckMetadata(t *testing.T) {
+	profile := &Profile{LeafField: "leaf", ChainField: "chain", CertField: "certificate"}
+	for field, reserved := range map[string]bool{"leaf": true, "chain": true, keyVersionField: true, "owner": false} {
+		entry := &ManifestEntry{Metadata: map[string]string{field: "x"}}
+		if err := entry.CheckMetadata(profile); (err != nil) != reserved {
+			t.Errorf("field %q: expected reserved %v, got %v", field, reserved, err)
+		}
+	}
+}
+
+func TestReadImportState(t *testing.T) {
+	chain := testChain()
+	leafKey := publicKeyPEM(t, chain[0].PublicKey)
+	otherKey := publicKeyPEM(t, chain[1].PublicKey)
+	server := newFakeVault(t, map[string]any{
+		"/v1/transit/keys/imported": map[string]any{"type": "rsa-2048", "latest_version": 2, "keys": map[string]any{
+			"1": map[string]any{"public_key": otherKey},
+			"2": map[string]any{"public_key": leafKey},
+		}},
+		"/v1/secret/data/imported": map[string]any{
+			"data":     map[string]any{"certificate": encodePEM(chain), keyVersionField: 2, "owner": "release-team"},
+			"metadata": map[string]any{"version": 3},
+		},
+		"/v1/transit/keys/unpaired": map[string]any{"type": "rsa-2048", "latest_version": 1, "keys": map[string]any{
+			"1": map[string]any{"public_key": leafKey},
+		}},
+		"/v1/secret/data/unpaired": map[string]any{
+			"data":     map[string]any{"certificate": encodePEM(chain)},
+			"metadata": map[string]any{"version": 1},
+		},
+		"/v1/transit/keys/other": map[string]any{"type": "rsa-2048", "latest_version": 1, "keys": map[string]any{
+			"1": map[string]any{"public_key": otherKey},
+		}},
+	})
+	profile := &Profile{Name: "dev", Address: server.URL, Auth: &AuthConfig{Token: "root"}}
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(context.Background(), profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		certs       int
+		fields      map[string]string
+		exists      bool
+		keyVersion  int
+		chainStored bool
+	}{
+		{name: "imported", certs: 2, fields: map[string]string{"owner": "release-team"}, exists: true, keyVersion: 2, chainStored: true},
+		{name: "imported", certs: 1, exists: true, keyVersion: 2},
+		{name: "imported", certs: 2, fields: map[string]string{"owner": "ci-team"}, exists: true, keyVersion: 2},
+		{name: "unpaired", certs: 2, exists: true, keyVersion: 1},
+		{name: "other", certs: 2, exists: true},
+		{name: "missing", certs: 2},
+	}
+	for _, tt := range tests {
+		state, err := ReadImportState(context.Background(), client, profile, tt.name, chain[0].PublicKey, chain[:tt.certs], tt.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.KeyExists != tt.exists || state.KeyVersion != tt.keyVersion || state.ChainStored != tt.chainStored {
+			t.Errorf("%s with %d certificates and %v: unexpected state %+v", tt.name, tt.certs, tt.fields, state)
+		}
+	}
+
+	// a private key is compared by its public key
+	state, err := ReadImportState(context.Background(), client, profile, "other", testhelper.GetRSARootCertificate().PrivateKey, chain, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.KeyVersion != 1 {
+		t.Errorf("expected the private key to match version 1, got %+v", state)
+	}
+}
@@ -0,0 +1,63 @@
+package keyvault
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault-client-go"
+	"strings"
+)
+
+// EnableProfileMounts enables the transit and KV secrets engines of the
+// profile if they are not mounted, and returns the mounts enabled. Existing
+// mounts of another type, or another KV version, are reported as errors.
+func EnableProfileMounts(ctx context.Context, client *vault.Client, profile *Profile) ([]string, error) {
+	resp, err := client.Read(ctx, "sys/mounts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets engines, %v", err)
+	}
+	var mounts map[string]interface{}
+	if resp != nil {
+		mounts = resp.Data
+	}
+
+	kvVersion := fmt.Sprint(profile.KVVersion)
+	wanted := []struct {
+		mount, engineType, version string
+	}{
+		{profile.TransitMount, "transit", ""},
+		{profile.KVMount, "kv", kvVersion},
+	}
+	var enabled []string
+	for _, w := range wanted {
+		existing, ok := mounts[w.mount+"/"].(map[string]interface{})
+		if !ok {
+			body := map[string]interface{}{"type": w.engineType}
+			if w.version != "" {
+				body["options"] = map[string]interface{}{"version": w.version}
+			}
+			if _, err := client.Write(ctx, "sys/mounts/"+w.mount, body); err != nil {
+				return enabled, fmt.Errorf("failed to enable the %s secrets engine at %s, %v", w.engineType, w.mount, err)
+			}
+			enabled = append(enabled, w.mount)
+			continue
+		}
+		engineType, _ := existing["type"].(string)
+		if engineType != w.engineType {
+			return enabled, fmt.Errorf("%s is a %s secrets engine, not %s", w.mount, engineType, w.engineType)
+		}
+		if w.version == "" {
+			continue
+		}
+		// KV mounts without a version option are version 1
+		version := "1"
+		if options, ok := existing["options"].(map[string]interface{}); ok {
+			if v, ok := options["version"].(string); ok && v != "" {
+				version = strings.TrimPrefix(v, "v")
+			}
+		}
+		if version != w.version {
+			return enabled, fmt.Errorf("%s is a KV version %s secrets engine, the profile expects version %s", w.mount, version, w.version)
+		}
+	}
+	return enabled, nil
+}
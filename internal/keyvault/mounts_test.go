@@ -0,0 +1,67 @@
+package keyvault
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEnableProfileMounts(t *testing.T) {
+	mounts := map[string]any{
+		"sys/": map[string]any{"type": "system"},
+	}
+	written := map[string]map[string]any{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/sys/mounts" && r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(map[string]any{"data": mounts})
+		case strings.HasPrefix(r.URL.Path, "/v1/sys/mounts/"):
+			var body map[string]any
+			json.NewDecoder(r.Body).Decode(&body)
+			written[strings.TrimPrefix(r.URL.Path, "/v1/sys/mounts/")] = body
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer server.Close()
+	profile := &Profile{Name: "dev", Address: server.URL, Auth: &AuthConfig{Token: "root"}, KVMount: "kv"}
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(context.Background(), profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enabled, err := EnableProfileMounts(context.Background(), client, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enabled) != 2 || written["transit"]["type"] != "transit" || written["kv"]["type"] != "kv" {
+		t.Fatalf("expected both mounts to be enabled, got %v, %v", enabled, written)
+	}
+	if options, _ := written["kv"]["options"].(map[string]any); options["version"] != "2" {
+		t.Errorf("expected a KV v2 mount, got %v", written["kv"])
+	}
+
+	written = map[string]map[string]any{}
+	mounts["transit/"] = map[string]any{"type": "transit"}
+	mounts["kv/"] = map[string]any{"type": "kv", "options": map[string]any{"version": "2"}}
+	if enabled, err := EnableProfileMounts(context.Background(), client, profile); err != nil || len(enabled) != 0 || len(written) != 0 {
+		t.Errorf("expected existing mounts to be left alone, got %v, %v, %v", enabled, written, err)
+	}
+
+	mounts["kv/"] = map[string]any{"type": "kv", "options": nil}
+	if _, err := EnableProfileMounts(context.Background(), client, profile); err == nil || !strings.Contains(err.Error(), "version 1") {
+		t.Errorf("expected a KV version mismatch, got %v", err)
+	}
+	mounts["transit/"] = map[string]any{"type": "kv"}
+	if _, err := EnableProfileMounts(context.Background(), client, profile); err == nil || !strings.Contains(err.Error(), "not transit") {
+		t.Errorf("expected a mount type mismatch, got %v", err)
+	}
+}
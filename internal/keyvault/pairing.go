@@ -0,0 +1,83 @@
+package keyvault
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault-client-go"
+)
+
+// AttachCertificateChain stores the chain at the KV path of the transit key,
+// paired with the key version its leaf certifies, so that signing switches to
+// that version. If keyVersion is not 0, the leaf must certify that version.
+// It returns the paired key version.
+func AttachCertificateChain(ctx context.Context, client *vault.Client, profile *Profile, name string, certs []*x509.Certificate, keyVersion int) (int, error) {
+	if len(certs) == 0 {
+		return 0, errors.New("no certificates to attach")
+	}
+	key, err := ReadTransitKey(ctx, client, profile, name)
+	if err != nil {
+		return 0, err
+	}
+	certified := CertifiedVersion(key, certs[0])
+	if certified == 0 {
+		return 0, fmt.Errorf("the leaf certificate %q does not certify any version of transit key %s/keys/%s", certs[0].Subject, profile.TransitMount, name)
+	}
+	if keyVersion != 0 && keyVersion != certified {
+		return 0, fmt.Errorf("the leaf certificate %q certifies version %d of transit key %s/keys/%s, not version %d", certs[0].Subject, certified, profile.TransitMount, name, keyVersion)
+	}
+
+	previous, err := ReadKVIfExists(ctx, client, profile, name)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := StoreCertificateChain(ctx, client, profile, name, certs, certified, nil, previous); err != nil {
+		return 0, err
+	}
+	return certified, nil
+}
+
+// PairCertificateChain pairs the chain stored for the transit key with the
+// key version its leaf certifies, if the chain is not paired yet. This keeps
+// signing on that version when new versions are added to the key. A key
+// without a chain is left alone.
+func PairCertificateChain(ctx context.Context, client *vault.Client, profile *Profile, name string) error {
+	chain, err := ReadCertificateChain(ctx, client, profile, name)
+	if err != nil {
+		if errors.Is(err, ErrSecretNotFound) {
+			return nil
+		}
+		return err
+	}
+	if chain.KeyVersion != 0 {
+		return nil
+	}
+	if _, err := AttachCertificateChain(ctx, client, profile, name, chain.Certificates, 0); err != nil {
+		return fmt.Errorf("failed to pair the current certificate chain with its key version, %v", err)
+	}
+	return nil
+}
+
+// StoreCertificateChain stores the chain at the KV path of the transit key,
+// paired with keyVersion, along with the extra fields. previous is the
+// secret read beforehand, nil if there was none: the write is a
+// check-and-set against its version, so that a concurrent change cannot be
+// silently overwritten, and its fields unrelated to the chain are kept. It
+// returns the new secret version.
+func StoreCertificateChain(ctx context.Context, client *vault.Client, profile *Profile, name string, certs []*x509.Certificate, keyVersion int, fields map[string]string, previous *KVSecret) (int, error) {
+	cas := 0
+	data := profile.CertificateData(certs, keyVersion)
+	for field, value := range fields {
+		data[field] = value
+	}
+	if previous != nil {
+		cas = previous.Version
+		for field, value := range previous.Data {
+			if _, ok := data[field]; !ok && !profile.isCertificateField(field) {
+				data[field] = value
+			}
+		}
+	}
+	return WriteKV(ctx, client, profile, name, data, cas)
+}
@@ -0,0 +1,130 @@
+package keyvault
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"github.com/notaryproject/notation-core-go/testhelper"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAttachCertificateChain(t *testing.T) {
+	chain := testChain()
+	leafKey := publicKeyPEM(t, chain[0].PublicKey)
+	otherKey := publicKeyPEM(t, chain[1].PublicKey)
+	var written map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data map[string]any
+		switch {
+		case r.URL.Path == "/v1/transit/keys/signing":
+			data = map[string]any{"type": "rsa-2048", "latest_version": 2, "keys": map[string]any{
+				"1": map[string]any{"public_key": otherKey},
+				"2": map[string]any{"public_key": leafKey},
+			}}
+		case r.URL.Path == "/v1/secret/data/signing" && r.Method == http.MethodGet:
+			data = map[string]any{
+				"data":     map[string]any{"certificate": encodePEM(chain[1:]), keyVersionField: 1, "owner": "release-team"},
+				"metadata": map[string]any{"version": 4},
+			}
+		case r.URL.Path == "/v1/secret/data/signing":
+			json.NewDecoder(r.Body).Decode(&written)
+			data = map[string]any{"version": 5}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+	defer server.Close()
+	profile := &Profile{Name: "dev", Address: server.URL, Auth: &AuthConfig{Token: "root"}}
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(context.Background(), profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := AttachCertificateChain(context.Background(), client, profile, "signing", chain, 1); err == nil || !strings.Contains(err.Error(), "certifies version 2") {
+		t.Errorf("expected a version mismatch error, got %v", err)
+	}
+	unrelated := []*x509.Certificate{testhelper.GetECLeafCertificate().Cert}
+	if _, err := AttachCertificateChain(context.Background(), client, profile, "signing", unrelated, 0); err == nil {
+		t.Error("expected an error for a certificate of another key")
+	}
+	if written != nil {
+		t.Fatal("expected nothing to be written on errors")
+	}
+
+	version, err := AttachCertificateChain(context.Background(), client, profile, "signing", chain, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Errorf("expected the chain to be paired with version 2, got %d", version)
+	}
+	data, _ := written["data"].(map[string]any)
+	options, _ := written["options"].(map[string]any)
+	if data["certificate"] != encodePEM(chain) || data[keyVersionField] != float64(2) || data["owner"] != "release-team" {
+		t.Errorf("unexpected secret data %v", data)
+	}
+	if options["cas"] != float64(4) {
+		t.Errorf("expected a check-and-set write against version 4, got %v", options)
+	}
+}
+
+func TestPairCertificateChain(t *testing.T) {
+	chain := testChain()
+	secret := map[string]any{"certificate": encodePEM(chain)}
+	writes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data map[string]any
+		switch {
+		case r.URL.Path == "/v1/transit/keys/signing":
+			data = map[string]any{"type": "rsa-2048", "latest_version": 3, "keys": map[string]any{
+				"1": map[string]any{"public_key": publicKeyPEM(t, chain[1].PublicKey)},
+				"2": map[string]any{"public_key": publicKeyPEM(t, chain[0].PublicKey)},
+			}}
+		case r.URL.Path == "/v1/secret/data/signing" && r.Method == http.MethodGet:
+			data = map[string]any{"data": secret, "metadata": map[string]any{"version": writes + 1}}
+		case r.URL.Path == "/v1/secret/data/signing":
+			var body struct {
+				Data map[string]any `json:"data"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			secret = body.Data
+			writes++
+			data = map[string]any{"version": writes + 1}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+	defer server.Close()
+	profile := &Profile{Name: "dev", Address: server.URL, Auth: &AuthConfig{Token: "root"}}
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(context.Background(), profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := PairCertificateChain(context.Background(), client, profile, "signing"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if writes != 1 || secret[keyVersionField] != float64(2) {
+		t.Errorf("expected one write pairing the chain with version 2, got %d writes and %v", writes, secret)
+	}
+	if err := PairCertificateChain(context.Background(), client, profile, "missing"); err != nil {
+		t.Errorf("expected a key without chain to be left alone, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package keyvault
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/hashicorp/vault-client-go"
+	"net/http"
+)
+
+// IssueCertificate has the role of a Vault PKI secrets engine sign the DER
+// encoded CSR, and returns the issued chain, leaf first.
+func IssueCertificate(ctx context.Context, client *vault.Client, mount string, role string, csr []byte, commonName string) ([]*x509.Certificate, error) {
+	resp, err := client.Write(ctx, mount+"/sign/"+role, map[string]interface{}{
+		"csr":         string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+		"common_name": commonName,
+		"format":      "pem",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign CSR with %s/sign/%s, %v", mount, role, err)
+	}
+	if resp == nil {
+		return nil, fmt.Errorf("empty response from %s/sign/%s", mount, role)
+	}
+	leaf, err := decodeCertificates(resp.Data["certificate"])
+	if err != nil || len(leaf) != 1 {
+		return nil, fmt.Errorf("unexpected certificate from %s/sign/%s", mount, role)
+	}
+	chainValue := resp.Data["ca_chain"]
+	if chainValue == nil {
+		chainValue = resp.Data["issuing_ca"]
+	}
+	chain, err := decodeCertificates(chainValue)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected CA chain from %s/sign/%s, %v", mount, role, err)
+	}
+	return append(leaf, chain...), nil
+}
+
+// PKIIssuer is an issuer of a Vault PKI secrets engine.
+type PKIIssuer struct {
+	Mount string
+	// ID is the issuer ID, "default" on Vault versions without multiple
+	// issuer support.
+	ID          string
+	Name        string
+	Certificate *x509.Certificate
+	Revoked     bool
+}
+
+// ListPKIIssuers returns the issuers of the PKI mount. Mounts of Vault
+// versions before 1.11 yield their single CA certificate.
+func ListPKIIssuers(ctx context.Context, client *vault.Client, mount string) ([]*PKIIssuer, error) {
+	resp, err := client.List(ctx, mount+"/issuers")
+	if vault.IsErrorStatus(err, http.StatusNotFound) {
+		return readLegacyPKIIssuer(ctx, client, mount)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list issuers of %s, %v", mount, err)
+	}
+	if resp == nil {
+		return nil, nil
+	}
+	items, _ := resp.Data["keys"].([]interface{})
+	issuers := make([]*PKIIssuer, 0, len(items))
+	for _, item := range items {
+		id, ok := item.(string)
+		if !ok {
+			continue
+		}
+		issuer, err := readPKIIssuer(ctx, client, mount, id)
+		if err != nil {
+			return nil, err
+		}
+		issuers = append(issuers, issuer)
+	}
+	return issuers, nil
+}
+
+func readPKIIssuer(ctx context.Context, client *vault.Client, mount string, id string) (*PKIIssuer, error) {
+	resp, err := client.Read(ctx, mount+"/issuer/"+id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read issuer %s/issuer/%s, %v", mount, id, err)
+	}
+	if resp == nil {
+		return nil, fmt.Errorf("empty response from %s/issuer/%s", mount, id)
+	}
+	certs, err := decodeCertificates(resp.Data["certificate"])
+	if err != nil || len(certs) != 1 {
+		return nil, fmt.Errorf("unexpected certificate of issuer %s/issuer/%s", mount, id)
+	}
+	name, _ := resp.Data["issuer_name"].(string)
+	revoked, _ := resp.Data["revoked"].(bool)
+	return &PKIIssuer{Mount: mount, ID: id, Name: name, Certificate: certs[0], Revoked: revoked}, nil
+}
+
+func readLegacyPKIIssuer(ctx context.Context, client *vault.Client, mount string) ([]*PKIIssuer, error) {
+	resp, err := client.Read(ctx, mount+"/cert/ca")
+	if vault.IsErrorStatus(err, http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CA certificate of %s, %v", mount, err)
+	}
+	if resp == nil || resp.Data["certificate"] == "" {
+		return nil, nil
+	}
+	certs, err := decodeCertificates(resp.Data["certificate"])
+	if err != nil || len(certs) != 1 {
+		return nil, fmt.Errorf("unexpected CA certificate of %s", mount)
+	}
+	return []*PKIIssuer{{Mount: mount, ID: "default", Certificate: certs[0]}}, nil
+}
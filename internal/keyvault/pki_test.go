@@ -0,0 +1,50 @@
+package keyvault
+
+import (
+	"context"
+	"testing"
+)
+
+func TestListPKIIssuers(t *testing.T) {
+	chain := testChain()
+	server := newFakeVault(t, map[string]any{
+		"/v1/pki/issuers": map[string]any{"keys": []string{"root-id", "old-id"}},
+		"/v1/pki/issuer/root-id": map[string]any{
+			"certificate": encodePEM(chain[1:]),
+			"issuer_name": "root-2024",
+		},
+		"/v1/pki/issuer/old-id": map[string]any{
+			"certificate": encodePEM(chain[1:]),
+			"revoked":     true,
+		},
+		"/v1/pki-legacy/cert/ca": map[string]any{"certificate": encodePEM(chain[1:])},
+	})
+	profile := &Profile{Name: "dev", Address: server.URL, Auth: &AuthConfig{Token: "root"}}
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(context.Background(), profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuers, err := ListPKIIssuers(context.Background(), client, "pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issuers) != 2 || issuers[0].Name != "root-2024" || issuers[0].Revoked || !issuers[1].Revoked || !issuers[0].Certificate.Equal(chain[1]) {
+		t.Errorf("unexpected issuers %+v", issuers)
+	}
+
+	issuers, err = ListPKIIssuers(context.Background(), client, "pki-legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issuers) != 1 || issuers[0].ID != "default" || issuers[0].Mount != "pki-legacy" {
+		t.Errorf("unexpected issuers of a pre-1.11 mount %+v", issuers)
+	}
+
+	if issuers, err = ListPKIIssuers(context.Background(), client, "missing"); err != nil || len(issuers) != 0 {
+		t.Errorf("expected no issuers for a missing mount, got %v, %v", issuers, err)
+	}
+}
@@ -0,0 +1,351 @@
+package keyvault
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TransitKeyTypes are the transit key types usable for notation signing.
+var TransitKeyTypes = []string{"rsa-2048", "rsa-3072", "rsa-4096", "ecdsa-p256", "ecdsa-p384", "ecdsa-p521"}
+
+// TransitKey describes an asymmetric transit key.
+type TransitKey struct {
+	Name             string
+	Type             string
+	LatestVersion    int
+	Exportable       bool
+	DeletionAllowed  bool
+	AutoRotatePeriod time.Duration
+	// Imported is set for keys created by importing key material, which
+	// transit only rotates if ImportedAllowRotation was set at import.
+	Imported              bool
+	ImportedAllowRotation bool
+	// PublicKeys holds the public key of each version.
+	PublicKeys map[int]crypto.PublicKey
+	// CreationTimes holds the creation time of each version.
+	CreationTimes map[int]time.Time
+}
+
+// Versions returns the available key versions in ascending order.
+func (k *TransitKey) Versions() []int {
+	versions := make([]int, 0, len(k.PublicKeys))
+	for version := range k.PublicKeys {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// PublicKey returns the public key of the version, 0 meaning the latest.
+func (k *TransitKey) PublicKey(version int) (crypto.PublicKey, error) {
+	if version == 0 {
+		version = k.LatestVersion
+	}
+	pub, ok := k.PublicKeys[version]
+	if !ok {
+		return nil, fmt.Errorf("transit key %q has no version %d", k.Name, version)
+	}
+	return pub, nil
+}
+
+// ErrTransitKeyNotFound is returned by ReadTransitKey for missing keys.
+var ErrTransitKeyNotFound = errors.New("transit key not found")
+
+// ReadTransitKey reads the transit key from the profile's transit mount.
+func ReadTransitKey(ctx context.Context, client *vault.Client, profile *Profile, name string) (*TransitKey, error) {
+	resp, err := client.Secrets.TransitReadKey(ctx, name, vault.WithMountPath(profile.TransitMount))
+	if err != nil {
+		if vault.IsErrorStatus(err, http.StatusNotFound) {
+			return nil, fmt.Errorf("%w: %s/keys/%s", ErrTransitKeyNotFound, profile.TransitMount, name)
+		}
+		return nil, fmt.Errorf("failed to read transit key %s/keys/%s, %v", profile.TransitMount, name, err)
+	}
+	if resp == nil || resp.Data == nil {
+		return nil, fmt.Errorf("%w: %s/keys/%s", ErrTransitKeyNotFound, profile.TransitMount, name)
+	}
+	key := &TransitKey{
+		Name:             name,
+		LatestVersion:    toInt(resp.Data["latest_version"]),
+		PublicKeys:       make(map[int]crypto.PublicKey),
+		CreationTimes:    make(map[int]time.Time),
+		AutoRotatePeriod: time.Duration(toInt(resp.Data["auto_rotate_period"])) * time.Second,
+	}
+	key.Type, _ = resp.Data["type"].(string)
+	key.Exportable, _ = resp.Data["exportable"].(bool)
+	key.DeletionAllowed, _ = resp.Data["deletion_allowed"].(bool)
+	key.Imported, _ = resp.Data["imported_key"].(bool)
+	key.ImportedAllowRotation, _ = resp.Data["imported_key_allow_rotation"].(bool)
+
+	versions, _ := resp.Data["keys"].(map[string]interface{})
+	for v, info := range versions {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			continue
+		}
+		details, ok := info.(map[string]interface{})
+		if !ok {
+			// symmetric keys only report creation times
+			continue
+		}
+		if created, ok := details["creation_time"].(string); ok {
+			key.CreationTimes[version], _ = time.Parse(time.RFC3339Nano, created)
+		}
+		pemKey, _ := details["public_key"].(string)
+		block, _ := pem.Decode([]byte(pemKey))
+		if block == nil {
+			continue
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key of transit key %q version %d, %v", name, version, err)
+		}
+		key.PublicKeys[version] = pub
+	}
+	return key, nil
+}
+
+// CreateTransitKey creates a non-exportable transit key of the given type.
+func CreateTransitKey(ctx context.Context, client *vault.Client, profile *Profile, name string, keyType string, autoRotatePeriod time.Duration) error {
+	if !isSupportedKeyType(keyType) {
+		return fmt.Errorf("unsupported key type %q, must be one of %s", keyType, strings.Join(TransitKeyTypes, ", "))
+	}
+	if _, err := ReadTransitKey(ctx, client, profile, name); err == nil {
+		return fmt.Errorf("transit key %s/keys/%s already exists", profile.TransitMount, name)
+	} else if !errors.Is(err, ErrTransitKeyNotFound) {
+		return err
+	}
+	_, err := client.Secrets.TransitWriteKey(ctx, name, schema.TransitWriteKeyRequest{
+		AllowPlaintextBackup: false,
+		AutoRotatePeriod:     int32(autoRotatePeriod.Seconds()),
+		Exportable:           false,
+		Type:                 keyType,
+	}, vault.WithMountPath(profile.TransitMount))
+	if err != nil {
+		return fmt.Errorf("failed to create transit key %s/keys/%s, %v", profile.TransitMount, name, err)
+	}
+	return nil
+}
+
+// ListTransitKeys returns the names of the keys in the profile's transit
+// mount.
+func ListTransitKeys(ctx context.Context, client *vault.Client, profile *Profile) ([]string, error) {
+	resp, err := client.Secrets.TransitListKeys(ctx, vault.WithMountPath(profile.TransitMount))
+	if err != nil {
+		if vault.IsErrorStatus(err, http.StatusNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list transit keys in %s, %v", profile.TransitMount, err)
+	}
+	if resp == nil {
+		return nil, nil
+	}
+	items, _ := resp.Data["keys"].([]interface{})
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		if key, ok := item.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// CheckRotatable returns an error if transit refuses to rotate the key,
+// which is the case of imported keys: their new versions must be imported
+// too.
+func (k *TransitKey) CheckRotatable() error {
+	if k.Imported && !k.ImportedAllowRotation {
+		return fmt.Errorf("transit key %s was imported and cannot be rotated by transit, import the new version with \"key import --key_name %s\" instead", k.Name, k.Name)
+	}
+	return nil
+}
+
+// RotateTransitKey adds a new version to the transit key and returns it.
+func RotateTransitKey(ctx context.Context, client *vault.Client, profile *Profile, name string) (int, error) {
+	if _, err := client.Secrets.TransitRotateKey(ctx, name, vault.WithMountPath(profile.TransitMount)); err != nil {
+		return 0, fmt.Errorf("failed to rotate transit key %s/keys/%s, %v", profile.TransitMount, name, err)
+	}
+	key, err := ReadTransitKey(ctx, client, profile, name)
+	if err != nil {
+		return 0, err
+	}
+	return key.LatestVersion, nil
+}
+
+// DeleteTransitKey allows the deletion of the transit key, then deletes it.
+func DeleteTransitKey(ctx context.Context, client *vault.Client, profile *Profile, name string) error {
+	// the typed config request would reset every other key setting
+	_, err := client.Write(ctx, profile.TransitMount+"/keys/"+name+"/config", map[string]interface{}{"deletion_allowed": true})
+	if err != nil {
+		return fmt.Errorf("failed to allow deletion of transit key %s/keys/%s, %v", profile.TransitMount, name, err)
+	}
+	if _, err := client.Secrets.TransitDeleteKey(ctx, name, vault.WithMountPath(profile.TransitMount)); err != nil {
+		return fmt.Errorf("failed to delete transit key %s/keys/%s, %v", profile.TransitMount, name, err)
+	}
+	return nil
+}
+
+// TransitKeyType returns the transit key type of a private or public key.
+func TransitKeyType(key crypto.PublicKey) (string, error) {
+	if signer, ok := key.(crypto.Signer); ok {
+		key = signer.Public()
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		keyType := fmt.Sprintf("rsa-%d", k.N.BitLen())
+		if isSupportedKeyType(keyType) {
+			return keyType, nil
+		}
+		return "", fmt.Errorf("unsupported RSA key size %d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		keyType := "ecdsa-p" + strings.TrimPrefix(k.Curve.Params().Name, "P-")
+		if isSupportedKeyType(keyType) {
+			return keyType, nil
+		}
+		return "", fmt.Errorf("unsupported ECDSA curve %s", k.Curve.Params().Name)
+	default:
+		return "", fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+func isSupportedKeyType(keyType string) bool {
+	for _, t := range TransitKeyTypes {
+		if t == keyType {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitHashAlgorithm returns the transit hash_algorithm name of a hash.
+func TransitHashAlgorithm(hash crypto.Hash) (string, error) {
+	switch hash {
+	case crypto.SHA256:
+		return "sha2-256", nil
+	case crypto.SHA384:
+		return "sha2-384", nil
+	case crypto.SHA512:
+		return "sha2-512", nil
+	default:
+		return "", fmt.Errorf("unsupported hash algorithm %v", hash)
+	}
+}
+
+// transitSign signs a base64 encoded digest with the key version, 0 meaning
+// the latest version. It returns the ASN.1 encoded signature and the key
+// version that produced it.
+func transitSign(ctx context.Context, client *vault.Client, profile *Profile, key string, version int, encodedDigest string, signAlgorithm string, hashAlgorithm string) ([]byte, int, error) {
+	resp, err := client.Secrets.TransitSign(ctx, key, schema.TransitSignRequest{
+		HashAlgorithm:       hashAlgorithm,
+		Input:               encodedDigest,
+		MarshalingAlgorithm: "asn1",
+		KeyVersion:          int32(version),
+		Prehashed:           true,
+		SaltLength:          "hash",
+		SignatureAlgorithm:  signAlgorithm,
+	}, vault.WithMountPath(profile.TransitMount))
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp == nil {
+		return nil, 0, errors.New("empty transit sign response")
+	}
+
+	// signatures are formatted as vault:v<version>:<base64 signature>
+	signature, _ := resp.Data["signature"].(string)
+	items := strings.Split(signature, ":")
+	if len(items) != 3 || items[0] != "vault" || !strings.HasPrefix(items[1], "v") {
+		return nil, 0, fmt.Errorf("unexpected transit signature format %q", signature)
+	}
+	signedVersion, err := strconv.Atoi(strings.TrimPrefix(items[1], "v"))
+	if err != nil {
+		return nil, 0, fmt.Errorf("unexpected transit signature version %q", items[1])
+	}
+	sigBytes, err := base64.StdEncoding.DecodeString(items[2])
+	if err != nil {
+		return nil, 0, err
+	}
+	return sigBytes, signedVersion, nil
+}
+
+// TransitSigner is a crypto.Signer backed by a version of a transit key, so
+// that certificates and CSRs can be signed without the private key ever
+// leaving Vault.
+type TransitSigner struct {
+	ctx     context.Context
+	client  *vault.Client
+	profile *Profile
+	key     string
+	version int
+	public  crypto.PublicKey
+}
+
+// NewTransitSigner returns a signer for the key version, 0 meaning the latest.
+func NewTransitSigner(ctx context.Context, client *vault.Client, profile *Profile, key string, version int) (*TransitSigner, error) {
+	transitKey, err := ReadTransitKey(ctx, client, profile, key)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		version = transitKey.LatestVersion
+	}
+	pub, err := transitKey.PublicKey(version)
+	if err != nil {
+		return nil, err
+	}
+	return &TransitSigner{
+		ctx:     ctx,
+		client:  client,
+		profile: profile,
+		key:     key,
+		version: version,
+		public:  pub,
+	}, nil
+}
+
+// Public returns the public key of the signer's key version.
+func (s *TransitSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Version returns the transit key version used by the signer.
+func (s *TransitSigner) Version() int {
+	return s.version
+}
+
+// Sign signs a digest with transit. RSA keys sign with PSS if opts is an
+// *rsa.PSSOptions and with PKCS #1 v1.5 otherwise.
+func (s *TransitSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hashAlgorithm, err := TransitHashAlgorithm(opts.HashFunc())
+	if err != nil {
+		return nil, err
+	}
+	var signAlgorithm string
+	switch s.public.(type) {
+	case *rsa.PublicKey:
+		signAlgorithm = "pkcs1v15"
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			signAlgorithm = "pss"
+		}
+	case *ecdsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", s.public)
+	}
+	sig, _, err := transitSign(s.ctx, s.client, s.profile, s.key, s.version, base64.StdEncoding.EncodeToString(digest), signAlgorithm, hashAlgorithm)
+	return sig, err
+}
@@ -0,0 +1,132 @@
+package keyvault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/OliverShang/notation-hc-vault/internal/crypto"
+	"github.com/notaryproject/notation-core-go/testhelper"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestTransitSigner signs a self-signed certificate with a transit stand-in
+// holding an ECDSA key, and checks the resulting signature locally.
+func TestTransitSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(key.Public())
+	publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data map[string]any
+		switch r.URL.Path {
+		case "/v1/transit/keys/dev":
+			data = map[string]any{
+				"type":           "ecdsa-p256",
+				"latest_version": 1,
+				"keys":           map[string]any{"1": map[string]any{"public_key": publicKey, "creation_time": "2023-05-01T00:00:00Z"}},
+			}
+		case "/v1/transit/sign/dev":
+			var req struct {
+				Input         string `json:"input"`
+				HashAlgorithm string `json:"hash_algorithm"`
+				Prehashed     bool   `json:"prehashed"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			digest, _ := base64.StdEncoding.DecodeString(req.Input)
+			if !req.Prehashed || req.HashAlgorithm != "sha2-256" || len(digest) != sha256.Size {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			sig, _ := ecdsa.SignASN1(rand.Reader, key, digest)
+			data = map[string]any{"signature": "vault:v1:" + base64.StdEncoding.EncodeToString(sig)}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+	defer server.Close()
+
+	profile := &Profile{Name: "dev", Address: server.URL, Auth: &AuthConfig{Token: "root"}}
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	client, err := NewClient(ctx, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewTransitSigner(ctx, client, profile, "dev", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.Version() != 1 || !key.PublicKey.Equal(signer.Public()) {
+		t.Fatalf("unexpected signer key version %d", signer.Version())
+	}
+	subject, _ := crypto.ParseSubject("CN=dev")
+	cert, err := crypto.CreateSelfSignedCertificate(signer, subject, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := NewTransitSigner(ctx, client, profile, "missing", 0); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+}
+
+func TestTransitKeyType(t *testing.T) {
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	p224, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	tests := []struct {
+		key  any
+		want string
+	}{
+		{testhelper.GetRSALeafCertificate().PrivateKey, "rsa-3072"},
+		{testhelper.GetRSALeafCertificate().Cert.PublicKey, "rsa-3072"},
+		{p384, "ecdsa-p384"},
+		{p224, ""},
+	}
+	for _, tt := range tests {
+		got, err := TransitKeyType(tt.key)
+		if got != tt.want || (err == nil) != (tt.want != "") {
+			t.Errorf("TransitKeyType(%T) = %q, %v, want %q", tt.key, got, err, tt.want)
+		}
+	}
+}
+
+func TestTransitKeyCheckRotatable(t *testing.T) {
+	tests := []struct {
+		name string
+		key  TransitKey
+		ok   bool
+	}{
+		{name: "generated", key: TransitKey{Name: "generated"}, ok: true},
+		{name: "imported", key: TransitKey{Name: "imported", Imported: true}},
+		{name: "imported with rotation", key: TransitKey{Name: "rotating", Imported: true, ImportedAllowRotation: true}, ok: true},
+	}
+	for _, tt := range tests {
+		err := tt.key.CheckRotatable()
+		if tt.ok != (err == nil) {
+			t.Errorf("%s: unexpected result %v", tt.name, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "key import --key_name imported") {
+			t.Errorf("%s: expected the error to point to key import, got %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package signature
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/notaryproject/notation-core-go/signature"
+	"github.com/notaryproject/notation-go/plugin/proto"
+	"os"
+	"time"
+)
+
+// DescribeKey reports the key spec of the key, read from the leaf
+// certificate of its chain.
+func DescribeKey(ctx context.Context, req *proto.DescribeKeyRequest) (*proto.DescribeKeyResponse, error) {
+	if req == nil || req.KeyID == "" {
+		return nil, &proto.RequestError{
+			Code: proto.ErrorCodeValidation,
+			Err:  errors.New("invalid request input"),
+		}
+	}
+
+	vaultClient, err := keyvault.NewVaultClientFromKeyID(ctx, req.KeyID, req.PluginConfig)
+	if err != nil {
+		return nil, err
+	}
+	// report the resolved alias, notation only surfaces stderr in debug logs
+	if target := vaultClient.Target(); target.Alias != "" {
+		fmt.Fprintf(os.Stderr, "key alias %q resolved to %s\n", req.KeyID, target)
+	}
+
+	certs, err := vaultClient.GetCertificateChain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if warning := keyvault.ExpiryWarning(certs, vaultClient.Profile().ExpiryWarningWindow(), time.Now()); warning != "" {
+		fmt.Fprintf(os.Stderr, "warning: key %q: %s, renew it before signing fails\n", req.KeyID, warning)
+	}
+	leafCert := certs[0]
+	// extract key spec from certificate
+	keySpec, err := signature.ExtractKeySpec(leafCert)
+	if err != nil {
+		return nil, err
+	}
+	encodedKeySpec, err := proto.EncodeKeySpec(keySpec)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.DescribeKeyResponse{
+		KeyID:   req.KeyID,
+		KeySpec: encodedKeySpec,
+	}, nil
+}
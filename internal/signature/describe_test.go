@@ -0,0 +1,31 @@
+package signature
+
+import (
+	"context"
+	"errors"
+	"github.com/notaryproject/notation-go/plugin/proto"
+	"net/http"
+	"testing"
+)
+
+func TestDescribeKey(t *testing.T) {
+	vault := &slowVault{}
+	setupSlowVault(t, vault)
+
+	resp, err := DescribeKey(context.Background(), &proto.DescribeKeyRequest{KeyID: "signing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.KeyID != "signing" || resp.KeySpec != proto.KeySpecRSA3072 {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	vault.kvStatus = http.StatusForbidden
+	if _, err := DescribeKey(context.Background(), &proto.DescribeKeyRequest{KeyID: "signing"}); err == nil {
+		t.Error("expected an error without certificate chain")
+	}
+	var reqErr *proto.RequestError
+	if _, err := DescribeKey(context.Background(), &proto.DescribeKeyRequest{}); !errors.As(err, &reqErr) || reqErr.Code != proto.ErrorCodeValidation {
+		t.Errorf("expected a validation error for a request without key ID, got %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package signature
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/OliverShang/notation-hc-vault/internal/version"
+	"github.com/notaryproject/notation-core-go/signature"
+	"github.com/notaryproject/notation-core-go/signature/jws"
+	"github.com/notaryproject/notation-go/plugin/proto"
+	"time"
+)
+
+// timestampHeader is the JWS unprotected header holding the RFC 3161
+// timestamp token, base64 encoded.
+const timestampHeader = "io.cncf.notary.timestampSignature"
+
+// GenerateEnvelope signs the payload with the transit key and returns a JWS
+// signature envelope. If the profile configures a time stamping authority,
+// the signature is countersigned with a timestamp token embedded as an
+// unsigned attribute.
+func GenerateEnvelope(ctx context.Context, req *proto.GenerateEnvelopeRequest) (*proto.GenerateEnvelopeResponse, error) {
+	// validate request
+	if req == nil || req.KeyID == "" || len(req.Payload) == 0 || req.PayloadType == "" {
+		return nil, &proto.RequestError{
+			Code: proto.ErrorCodeValidation,
+			Err:  errors.New("invalid request input"),
+		}
+	}
+	if req.SignatureEnvelopeType != jws.MediaTypeEnvelope {
+		return nil, &proto.RequestError{
+			Code: proto.ErrorCodeValidation,
+			Err:  fmt.Errorf("unsupported signature envelope type %q, only %s is supported", req.SignatureEnvelopeType, jws.MediaTypeEnvelope),
+		}
+	}
+
+	vaultClient, err := keyvault.NewVaultClientFromKeyID(ctx, req.KeyID, req.PluginConfig)
+	if err != nil {
+		return nil, &proto.RequestError{
+			Code: proto.ErrorCodeGeneric,
+			Err:  fmt.Errorf("failed to get vault client, %v", err),
+		}
+	}
+	chain, keyVersion, err := getCertificateChain(ctx, vaultClient)
+	if err != nil {
+		return nil, &proto.RequestError{
+			Code: proto.ErrorCodeGeneric,
+			Err:  fmt.Errorf("failed to get certificate chain, %v", err),
+		}
+	}
+	keySpec, err := signature.ExtractKeySpec(chain[0])
+	if err != nil {
+		return nil, &proto.RequestError{
+			Code: proto.ErrorCodeValidation,
+			Err:  fmt.Errorf("failed to get keySpec, %v", err),
+		}
+	}
+
+	signer := &vaultSigner{ctx: ctx, client: vaultClient, keySpec: keySpec, keyVersion: keyVersion, chain: chain}
+	signReq := &signature.SignRequest{
+		Payload: signature.Payload{
+			ContentType: req.PayloadType,
+			Content:     req.Payload,
+		},
+		Signer:        signer,
+		SigningTime:   time.Now(),
+		SigningScheme: signature.SigningSchemeX509,
+		SigningAgent:  "notation-hc-vault/" + version.GetVersion(),
+	}
+	if req.ExpiryDurationInSeconds != 0 {
+		signReq.Expiry = signReq.SigningTime.Add(time.Duration(req.ExpiryDurationInSeconds) * time.Second)
+	}
+	env, err := signature.NewEnvelope(req.SignatureEnvelopeType)
+	if err != nil {
+		return nil, &proto.RequestError{
+			Code: proto.ErrorCodeGeneric,
+			Err:  err,
+		}
+	}
+	envelope, err := env.Sign(signReq)
+	if err != nil {
+		// failures of the vault signer keep their error code
+		if signer.err != nil {
+			return nil, signer.err
+		}
+		return nil, &proto.RequestError{
+			Code: proto.ErrorCodeGeneric,
+			Err:  fmt.Errorf("failed to generate the signature envelope, %v", err),
+		}
+	}
+
+	if config := vaultClient.Profile().Timestamp; config != nil {
+		token, err := requestTimestamp(ctx, config, keySpec.SignatureAlgorithm().Hash(), signer.signature)
+		if err != nil {
+			return nil, &proto.RequestError{
+				Code: proto.ErrorCodeGeneric,
+				Err:  fmt.Errorf("failed to timestamp the signature with %s, %v", config.URL, err),
+			}
+		}
+		if envelope, err = embedTimestamp(envelope, token); err != nil {
+			return nil, &proto.RequestError{
+				Code: proto.ErrorCodeGeneric,
+				Err:  err,
+			}
+		}
+	}
+
+	return &proto.GenerateEnvelopeResponse{
+		SignatureEnvelope:     envelope,
+		SignatureEnvelopeType: req.SignatureEnvelopeType,
+	}, nil
+}
+
+// vaultSigner signs JWS signing inputs with the transit key version the
+// certificate chain, read and checked beforehand, is paired with.
+type vaultSigner struct {
+	ctx        context.Context
+	client     *keyvault.VaultClientWrapper
+	keySpec    signature.KeySpec
+	keyVersion int
+	chain      []*x509.Certificate
+
+	// signature and err are the outcome of the last Sign call.
+	signature []byte
+	err       error
+}
+
+func (s *vaultSigner) Sign(payload []byte) ([]byte, []*x509.Certificate, error) {
+	sig, err := signWithKeyVersion(s.ctx, s.client, s.keySpec, s.keyVersion, payload)
+	if err != nil {
+		s.err = err
+		return nil, nil, err
+	}
+	s.signature = sig
+	return sig, s.chain, nil
+}
+
+func (s *vaultSigner) KeySpec() (signature.KeySpec, error) {
+	return s.keySpec, nil
+}
+
+// embedTimestamp sets the timestamp token in the unprotected header of a JWS
+// envelope, leaving the rest of the envelope as is.
+func embedTimestamp(envelope, token []byte) ([]byte, error) {
+	var env map[string]json.RawMessage
+	if err := json.Unmarshal(envelope, &env); err != nil {
+		return nil, fmt.Errorf("malformed signature envelope, %v", err)
+	}
+	header := map[string]any{}
+	if raw, ok := env["header"]; ok {
+		if err := json.Unmarshal(raw, &header); err != nil {
+			return nil, fmt.Errorf("malformed signature envelope header, %v", err)
+		}
+	}
+	// a []byte is base64 encoded like the other binary headers
+	header[timestampHeader] = token
+	raw, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	env["header"] = raw
+	return json.Marshal(env)
+}
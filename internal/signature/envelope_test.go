@@ -0,0 +1,102 @@
+package signature
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/notaryproject/notation-core-go/signature/jws"
+	"github.com/notaryproject/notation-go/plugin/proto"
+	"go.mozilla.org/pkcs7"
+	"testing"
+)
+
+// setTimestampConfig adds a timestamp config to the profile of the slow
+// Vault stand-in.
+func setTimestampConfig(t *testing.T, config *keyvault.TimestampConfig) {
+	t.Helper()
+	setProfileConfig(t, "timestamp", config)
+}
+
+func envelopeRequest() *proto.GenerateEnvelopeRequest {
+	return &proto.GenerateEnvelopeRequest{
+		KeyID:                 "signing",
+		PayloadType:           "application/vnd.cncf.notary.payload.v1+json",
+		SignatureEnvelopeType: jws.MediaTypeEnvelope,
+		Payload:               []byte(`{"targetArtifact":{}}`),
+	}
+}
+
+func TestGenerateEnvelope(t *testing.T) {
+	vault := &slowVault{}
+	setupSlowVault(t, vault)
+	tsa := newTSAServer(t, x509.ExtKeyUsageTimeStamping)
+
+	// without a TSA, the envelope has no timestamp
+	resp, err := GenerateEnvelope(context.Background(), envelopeRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := jws.ParseEnvelope(resp.SignatureEnvelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := env.Content()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.SignatureEnvelopeType != jws.MediaTypeEnvelope || len(content.SignerInfo.UnsignedAttributes.TimestampSignature) != 0 {
+		t.Errorf("unexpected envelope %s", resp.SignatureEnvelope)
+	}
+	if len(content.SignerInfo.CertificateChain) != 2 || !content.SignerInfo.CertificateChain[0].Equal(vault.chain[0]) {
+		t.Error("expected the chain of the key in the envelope")
+	}
+	if vault.chainReads != 1 || len(vault.signVersions) != 1 {
+		t.Errorf("expected a single chain read and signature, got %d and %d", vault.chainReads, len(vault.signVersions))
+	}
+
+	setTimestampConfig(t, &keyvault.TimestampConfig{URL: tsa.URL, RootCert: writeRootCert(t, tsa.root)})
+	if resp, err = GenerateEnvelope(context.Background(), envelopeRequest()); err != nil {
+		t.Fatal(err)
+	}
+	if env, err = jws.ParseEnvelope(resp.SignatureEnvelope); err != nil {
+		t.Fatal(err)
+	}
+	if content, err = env.Content(); err != nil {
+		t.Fatal(err)
+	}
+	token := content.SignerInfo.UnsignedAttributes.TimestampSignature
+	if len(token) == 0 {
+		t.Fatalf("expected a timestamp token in the envelope %s", resp.SignatureEnvelope)
+	}
+	p7, err := pkcs7.Parse(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(p7.Content, &info); err != nil {
+		t.Fatal(err)
+	}
+	digest, _ := computeHash(content.SignerInfo.SignatureAlgorithm.Hash(), content.SignerInfo.Signature)
+	if !bytes.Equal(info.MessageImprint.HashedMessage, digest) {
+		t.Error("expected the timestamp token to be over the signature of the envelope")
+	}
+
+	// a failing TSA fails the envelope generation
+	tsa.set(func(s *tsaServer) { s.status = 2 })
+	if _, err := GenerateEnvelope(context.Background(), envelopeRequest()); err == nil {
+		t.Error("expected a refused timestamp to fail")
+	}
+}
+
+func TestGenerateEnvelopeUnsupportedType(t *testing.T) {
+	req := envelopeRequest()
+	req.SignatureEnvelopeType = "application/cose"
+	_, err := GenerateEnvelope(context.Background(), req)
+	var reqErr *proto.RequestError
+	if !errors.As(err, &reqErr) || reqErr.Code != proto.ErrorCodeValidation {
+		t.Errorf("expected a validation error, got %v", err)
+	}
+}
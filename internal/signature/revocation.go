@@ -0,0 +1,280 @@
+package signature
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"golang.org/x/crypto/ocsp"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// maxOCSPResponseSize bounds OCSP responses, which hold a single status.
+const maxOCSPResponseSize = 64 * 1024
+
+// RevokedError is returned when the signing certificate is revoked.
+type RevokedError struct {
+	Subject   string
+	RevokedAt time.Time
+	// Source is the OCSP responder or CRL distribution point that reported
+	// the revocation.
+	Source string
+}
+
+func (e *RevokedError) Error() string {
+	return fmt.Sprintf("certificate %q was revoked on %s, according to %s", e.Subject, e.RevokedAt.Format(time.RFC3339), e.Source)
+}
+
+// revocationChecker checks the revocation status of a certificate with the
+// OCSP responders and CRL distribution points it lists. Responses are cached
+// on disk until their next update.
+type revocationChecker struct {
+	config   *keyvault.RevocationConfig
+	client   *http.Client
+	cacheDir string
+	now      func() time.Time
+}
+
+func newRevocationChecker(config *keyvault.RevocationConfig) *revocationChecker {
+	c := &revocationChecker{
+		config: config,
+		client: &http.Client{Timeout: config.RequestTimeout()},
+		now:    time.Now,
+	}
+	if !config.DisableCache {
+		if dir, err := keyvault.CacheDir(); err == nil {
+			c.cacheDir = filepath.Join(dir, "revocation")
+		}
+	}
+	return c
+}
+
+// checkRevocation refuses a revoked leaf certificate. The OCSP responders
+// are queried first and the CRLs are only downloaded if none of them gives
+// a definitive status. A status that cannot be determined fails the check
+// unless the config fails open.
+func checkRevocation(ctx context.Context, config *keyvault.RevocationConfig, certs []*x509.Certificate) error {
+	return newRevocationChecker(config).check(ctx, certs)
+}
+
+func (c *revocationChecker) check(ctx context.Context, certs []*x509.Certificate) error {
+	leaf := certs[0]
+	if bytes.Equal(leaf.RawIssuer, leaf.RawSubject) && leaf.CheckSignatureFrom(leaf) == nil {
+		// a self-signed certificate cannot be revoked by anyone else
+		return nil
+	}
+	if len(certs) < 2 {
+		return c.unknown(leaf, errors.New("the chain has no issuer certificate"))
+	}
+	issuer := certs[1]
+	if len(leaf.OCSPServer) == 0 && len(leaf.CRLDistributionPoints) == 0 {
+		return c.unknown(leaf, errors.New("the certificate lists no OCSP responder nor CRL distribution point"))
+	}
+
+	var errs []error
+	for _, server := range leaf.OCSPServer {
+		revoked, err := c.checkOCSP(ctx, server, leaf, issuer)
+		if err == nil {
+			return revokedError(revoked)
+		}
+		errs = append(errs, fmt.Errorf("OCSP %s: %v", server, err))
+	}
+	for _, point := range leaf.CRLDistributionPoints {
+		revoked, err := c.checkCRL(ctx, point, leaf, issuer)
+		if err == nil {
+			return revokedError(revoked)
+		}
+		errs = append(errs, fmt.Errorf("CRL %s: %v", point, err))
+	}
+	return c.unknown(leaf, errors.Join(errs...))
+}
+
+// revokedError returns revoked as an error, nil if it is nil.
+func revokedError(revoked *RevokedError) error {
+	if revoked == nil {
+		return nil
+	}
+	return revoked
+}
+
+// unknown reports a revocation status that could not be determined.
+func (c *revocationChecker) unknown(leaf *x509.Certificate, err error) error {
+	err = fmt.Errorf("failed to check the revocation status of certificate %q, %v", leaf.Subject, err)
+	if c.config.FailOpen {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		return nil
+	}
+	return err
+}
+
+// checkOCSP returns a RevokedError if the responder reports the certificate
+// as revoked, nil if it reports it as good, and an error if it gives no
+// definitive and current status.
+func (c *revocationChecker) checkOCSP(ctx context.Context, server string, leaf, issuer *x509.Certificate) (*RevokedError, error) {
+	request, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	cachePath := c.cachePath("ocsp", server, string(request))
+	parse := func(data []byte) (*ocsp.Response, error) {
+		resp, err := ocsp.ParseResponseForCert(data, leaf, issuer)
+		if err != nil {
+			return nil, err
+		}
+		now := c.now()
+		if now.Before(resp.ThisUpdate) || (!resp.NextUpdate.IsZero() && now.After(resp.NextUpdate)) {
+			return nil, errors.New("the response is not current")
+		}
+		return resp, nil
+	}
+
+	var resp *ocsp.Response
+	if data := c.loadCache(cachePath); data != nil {
+		resp, _ = parse(data)
+	}
+	if resp == nil {
+		data, err := c.fetch(ctx, server, request, maxOCSPResponseSize)
+		if err != nil {
+			return nil, err
+		}
+		if resp, err = parse(data); err != nil {
+			return nil, err
+		}
+		if !resp.NextUpdate.IsZero() {
+			c.storeCache(cachePath, data)
+		}
+	}
+
+	switch resp.Status {
+	case ocsp.Good:
+		return nil, nil
+	case ocsp.Revoked:
+		return &RevokedError{Subject: leaf.Subject.String(), RevokedAt: resp.RevokedAt, Source: server}, nil
+	default:
+		return nil, errors.New("the responder does not know the certificate")
+	}
+}
+
+// checkCRL is checkOCSP against the CRL at a distribution point.
+func (c *revocationChecker) checkCRL(ctx context.Context, point string, leaf, issuer *x509.Certificate) (*RevokedError, error) {
+	cachePath := c.cachePath("crl", point)
+	parse := func(data []byte) (*x509.RevocationList, error) {
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return nil, err
+		}
+		if err := crl.CheckSignatureFrom(issuer); err != nil {
+			return nil, fmt.Errorf("the CRL is not signed by the issuer, %v", err)
+		}
+		now := c.now()
+		if now.Before(crl.ThisUpdate) || (!crl.NextUpdate.IsZero() && now.After(crl.NextUpdate)) {
+			return nil, errors.New("the CRL is not current")
+		}
+		return crl, nil
+	}
+
+	var crl *x509.RevocationList
+	if data := c.loadCache(cachePath); data != nil {
+		crl, _ = parse(data)
+	}
+	if crl == nil {
+		data, err := c.fetch(ctx, point, nil, c.config.MaxCRLSize)
+		if err != nil {
+			return nil, err
+		}
+		if crl, err = parse(data); err != nil {
+			return nil, err
+		}
+		if !crl.NextUpdate.IsZero() {
+			c.storeCache(cachePath, data)
+		}
+	}
+
+	for _, revoked := range crl.RevokedCertificates {
+		if revoked.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+			return &RevokedError{Subject: leaf.Subject.String(), RevokedAt: revoked.RevocationTime, Source: point}, nil
+		}
+	}
+	return nil, nil
+}
+
+// fetch POSTs an OCSP request to the URL, or GETs it if request is nil,
+// and returns a response body of at most maxSize bytes.
+func (c *revocationChecker) fetch(ctx context.Context, rawURL string, request []byte, maxSize int64) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errors.New("unsupported URL, only http(s) is supported")
+	}
+	method, body := http.MethodGet, io.Reader(nil)
+	if request != nil {
+		method, body = http.MethodPost, bytes.NewReader(request)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if request != nil {
+		req.Header.Set("Content-Type", "application/ocsp-request")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("response larger than %d bytes", maxSize)
+	}
+	return data, nil
+}
+
+// cachePath returns the cache file of a response, keyed by its kind, the
+// URL it is served at and, for OCSP, the request.
+func (c *revocationChecker) cachePath(kind string, key ...string) string {
+	if c.cacheDir == "" {
+		return ""
+	}
+	h := sha256.New()
+	for _, k := range key {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+	}
+	return filepath.Join(c.cacheDir, kind+"-"+hex.EncodeToString(h.Sum(nil)))
+}
+
+// loadCache returns a cached response, nil if there is none. Cached
+// responses are parsed and verified again, and used until their next
+// update.
+func (c *revocationChecker) loadCache(path string) []byte {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func (c *revocationChecker) storeCache(path string, data []byte) {
+	if path == "" {
+		return
+	}
+	// a failure to cache only costs a request
+	keyvault.WriteFileAtomic(path, data)
+}
@@ -0,0 +1,252 @@
+package signature
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"golang.org/x/crypto/ocsp"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// revocationServer is an OCSP responder and CRL distribution point
+// stand-in for a CA issuing a single leaf certificate.
+type revocationServer struct {
+	*httptest.Server
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+	leaf  *x509.Certificate
+
+	mu sync.Mutex
+	// ocspStatus is the status the responder reports, -1 to fail requests.
+	ocspStatus int
+	revoked    bool
+	requests   map[string]int
+}
+
+func newRevocationServer(t *testing.T) *revocationServer {
+	t.Helper()
+	s := &revocationServer{requests: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+
+	var err error
+	if s.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Revocation CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, s.caKey.Public(), s.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:          big.NewInt(42),
+		Subject:               pkix.Name{CommonName: "Revocable"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		OCSPServer:            []string{s.URL + "/ocsp"},
+		CRLDistributionPoints: []string{s.URL + "/ca.crl"},
+	}, s.ca, leafKey.Public(), s.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.leaf, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (s *revocationServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[r.URL.Path]++
+	now := time.Now()
+	switch r.URL.Path {
+	case "/ocsp":
+		body, _ := io.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil || s.ocspStatus < 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		resp, err := ocsp.CreateResponse(s.ca, s.ca, ocsp.Response{
+			Status:       s.ocspStatus,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   now.Add(-time.Minute),
+			NextUpdate:   now.Add(time.Hour),
+			RevokedAt:    now.Add(-time.Minute),
+		}, s.caKey)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(resp)
+	case "/ca.crl":
+		var revoked []pkix.RevokedCertificate
+		if s.revoked {
+			revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: s.leaf.SerialNumber, RevocationTime: now.Add(-time.Minute)})
+		}
+		crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			Number:              big.NewInt(1),
+			ThisUpdate:          now.Add(-time.Minute),
+			NextUpdate:          now.Add(time.Hour),
+			RevokedCertificates: revoked,
+		}, s.ca, s.caKey)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write(crl)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *revocationServer) set(ocspStatus int, revoked bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ocspStatus, s.revoked = ocspStatus, revoked
+}
+
+func (s *revocationServer) requestCount(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func revocationConfig(t *testing.T, config *keyvault.RevocationConfig) *keyvault.RevocationConfig {
+	t.Helper()
+	profile := &keyvault.Profile{Name: "test", Address: "http://127.0.0.1:8200", RevocationCheck: config}
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestCheckRevocation(t *testing.T) {
+	ctx := context.Background()
+	server := newRevocationServer(t)
+	chain := []*x509.Certificate{server.leaf, server.ca}
+	config := revocationConfig(t, &keyvault.RevocationConfig{})
+
+	tests := []struct {
+		name       string
+		ocspStatus int
+		crlRevoked bool
+		revoked    bool
+	}{
+		{name: "good", ocspStatus: ocsp.Good},
+		{name: "revoked by OCSP", ocspStatus: ocsp.Revoked, revoked: true},
+		{name: "unknown to OCSP, good in CRL", ocspStatus: ocsp.Unknown},
+		{name: "OCSP down, revoked in CRL", ocspStatus: -1, crlRevoked: true, revoked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a fresh cache for each status
+			t.Setenv(keyvault.EnvCacheDir, t.TempDir())
+			server.set(tt.ocspStatus, tt.crlRevoked)
+			err := checkRevocation(ctx, config, chain)
+			var revokedErr *RevokedError
+			switch {
+			case tt.revoked:
+				if !errors.As(err, &revokedErr) || revokedErr.Subject != "CN=Revocable" {
+					t.Fatalf("expected a revoked certificate error, got %v", err)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckRevocationCache(t *testing.T) {
+	t.Setenv(keyvault.EnvCacheDir, t.TempDir())
+	ctx := context.Background()
+	server := newRevocationServer(t)
+	chain := []*x509.Certificate{server.leaf, server.ca}
+	config := revocationConfig(t, &keyvault.RevocationConfig{})
+
+	server.set(ocsp.Good, false)
+	for i := 0; i < 3; i++ {
+		if err := checkRevocation(ctx, config, chain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := server.requestCount("/ocsp"); n != 1 {
+		t.Errorf("expected the OCSP response to be cached until its next update, got %d requests", n)
+	}
+
+	// past the next update of the cached response, the responder is asked
+	// again
+	checker := newRevocationChecker(config)
+	checker.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if err := checker.check(ctx, chain); err == nil || !strings.Contains(err.Error(), "not current") {
+		t.Errorf("expected stale responses to be refused, got %v", err)
+	}
+	if n := server.requestCount("/ocsp"); n != 2 {
+		t.Errorf("expected a second OCSP request, got %d requests", n)
+	}
+
+	// a disabled cache asks the responder every time
+	config = revocationConfig(t, &keyvault.RevocationConfig{DisableCache: true})
+	for i := 0; i < 2; i++ {
+		if err := checkRevocation(ctx, config, chain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := server.requestCount("/ocsp"); n != 4 {
+		t.Errorf("expected an OCSP request per check with a disabled cache, got %d requests", n)
+	}
+}
+
+func TestCheckRevocationUnknown(t *testing.T) {
+	t.Setenv(keyvault.EnvCacheDir, t.TempDir())
+	ctx := context.Background()
+	server := newRevocationServer(t)
+	server.Close()
+	chain := []*x509.Certificate{server.leaf, server.ca}
+
+	err := checkRevocation(ctx, revocationConfig(t, &keyvault.RevocationConfig{Timeout: "1s"}), chain)
+	if err == nil || !strings.Contains(err.Error(), "failed to check the revocation status") {
+		t.Errorf("expected an undetermined status to fail, got %v", err)
+	}
+	if err := checkRevocation(ctx, revocationConfig(t, &keyvault.RevocationConfig{FailOpen: true}), chain); err != nil {
+		t.Errorf("expected an undetermined status to pass when failing open, got %v", err)
+	}
+	if err := checkRevocation(ctx, revocationConfig(t, &keyvault.RevocationConfig{}), chain[:1]); err == nil || !strings.Contains(err.Error(), "no issuer certificate") {
+		t.Errorf("expected a chain without issuer to fail, got %v", err)
+	}
+	// a self-signed certificate has nobody to revoke it
+	if err := checkRevocation(ctx, revocationConfig(t, &keyvault.RevocationConfig{}), chain[1:]); err != nil {
+		t.Errorf("unexpected error for a self-signed certificate %v", err)
+	}
+}
@@ -3,11 +3,18 @@ package signature
 import (
 	"context"
 	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	vaultcrypto "github.com/OliverShang/notation-hc-vault/internal/crypto"
 	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/notaryproject/notation-core-go/signature"
 	"github.com/notaryproject/notation-go/plugin/proto"
+	"math/big"
+	"sync"
+	"time"
 )
 
 func Sign(ctx context.Context, req *proto.GenerateSignatureRequest) (*proto.GenerateSignatureResponse, error) {
@@ -20,13 +27,20 @@ func Sign(ctx context.Context, req *proto.GenerateSignatureRequest) (*proto.Gene
 		}
 	}
 
-	vaultClient, err := keyvault.NewVaultClientFromKeyID(req.KeyID)
+	vaultClient, err := keyvault.NewVaultClientFromKeyID(ctx, req.KeyID, req.PluginConfig)
 	if err != nil {
 		return nil, &proto.RequestError{
 			Code: proto.ErrorCodeGeneric,
 			Err:  fmt.Errorf("failed to get vault client, %v", err),
 		}
 	}
+	if vaultClient.Profile().Timestamp != nil {
+		// a raw signature would reach notation without its timestamp
+		return nil, &proto.RequestError{
+			Code: proto.ErrorCodeValidation,
+			Err:  fmt.Errorf("profile %q of key %q timestamps signatures, which requires the plugin to generate the signature envelope", vaultClient.Profile().Name, req.KeyID),
+		}
+	}
 
 	// get keySpec
 	keySpec, err := proto.DecodeKeySpec(req.KeySpec)
@@ -53,54 +67,201 @@ func Sign(ctx context.Context, req *proto.GenerateSignatureRequest) (*proto.Gene
 		}
 	}
 
+	signatureAlgorithmString, err := proto.EncodeSigningAlgorithm(keySpec.SignatureAlgorithm())
+	if err != nil {
+		return nil, &proto.RequestError{
+			Code: proto.ErrorCodeGeneric,
+			Err:  fmt.Errorf("failed to encode signing algorithm, %v", err),
+		}
+	}
+
+	sigBytes, certs, err := signWithVault(ctx, vaultClient, keySpec, req.Payload)
+	if err != nil {
+		return nil, err
+	}
+	rawCertChain := make([][]byte, 0, len(certs))
+	for _, cert := range certs {
+		rawCertChain = append(rawCertChain, cert.Raw)
+	}
+
+	return &proto.GenerateSignatureResponse{
+		KeyID:            req.KeyID,
+		Signature:        sigBytes,
+		SigningAlgorithm: string(signatureAlgorithmString),
+		CertificateChain: rawCertChain,
+	}, nil
+}
+
+// transitInput is the transit sign request of a payload.
+type transitInput struct {
+	keySpec       signature.KeySpec
+	encodedHash   string
+	signAlgorithm string
+	hashAlgorithm string
+}
+
+func newTransitInput(keySpec signature.KeySpec, payload []byte) (*transitInput, error) {
+	protoKeySpec, err := proto.EncodeKeySpec(keySpec)
+	if err != nil {
+		return nil, &proto.RequestError{
+			Code: proto.ErrorCodeValidation,
+			Err:  err,
+		}
+	}
 	// get signing algorithm
-	signAlgorithm := getAlgorithmFromKeySpec(req.KeySpec)
-	if signAlgorithm == "" {
+	signAlgorithm, ok := getAlgorithmFromKeySpec(protoKeySpec)
+	if !ok {
+		return nil, &proto.RequestError{
+			Code: proto.ErrorCodeValidation,
+			Err:  errors.New("unrecognized key spec: " + string(protoKeySpec)),
+		}
+	}
+	transitHashAlgorithm, err := keyvault.TransitHashAlgorithm(keySpec.SignatureAlgorithm().Hash())
+	if err != nil {
 		return nil, &proto.RequestError{
 			Code: proto.ErrorCodeValidation,
-			Err:  errors.New("unrecognized key spec: " + string(req.KeySpec)),
+			Err:  err,
 		}
 	}
 
 	// compute hash for the payload
-	hashData, err := computeHash(keySpec.SignatureAlgorithm().Hash(), req.Payload)
+	hashData, err := computeHash(keySpec.SignatureAlgorithm().Hash(), payload)
 	if err != nil {
 		return nil, &proto.RequestError{
 			Code: proto.ErrorCodeGeneric,
 			Err:  fmt.Errorf("failed to compute hash for the payload, %v", err),
 		}
 	}
-	encodedHash := base64.StdEncoding.EncodeToString(hashData)
-	sigBytes, err := vaultClient.SignWithTransit(ctx, encodedHash, signAlgorithm)
+	return &transitInput{
+		keySpec:       keySpec,
+		encodedHash:   base64.StdEncoding.EncodeToString(hashData),
+		signAlgorithm: signAlgorithm,
+		hashAlgorithm: transitHashAlgorithm,
+	}, nil
+}
+
+// sign signs with the given key version, 0 meaning the latest, and returns
+// the signature in the form notation expects along with the version that
+// signed.
+func (in *transitInput) sign(ctx context.Context, vaultClient *keyvault.VaultClientWrapper, version int) ([]byte, int, error) {
+	sigBytes, signedVersion, err := vaultClient.SignWithKeyVersion(ctx, version, in.encodedHash, in.signAlgorithm, in.hashAlgorithm)
 	if err != nil {
-		return nil, &proto.RequestError{
-			Code: proto.ErrorCodeGeneric,
-			Err:  fmt.Errorf("failed to sign with Transit secret engine, %v", err),
+		return nil, 0, fmt.Errorf("failed to sign with Transit secret engine, %v", err)
+	}
+	if in.keySpec.Type == signature.KeyTypeEC {
+		// notation expects the raw r || s form of ECDSA signatures
+		if sigBytes, err = ecdsaRawSignature(sigBytes, in.keySpec.Size); err != nil {
+			return nil, 0, err
 		}
 	}
+	return sigBytes, signedVersion, nil
+}
 
-	signatureAlgorithmString, err := proto.EncodeSigningAlgorithm(keySpec.SignatureAlgorithm())
+// signingVersion returns the key version to sign with for a chain paired
+// with keyVersion, 0 if it is not paired.
+func signingVersion(vaultClient *keyvault.VaultClientWrapper, keyVersion int) (int, error) {
+	pinned := vaultClient.Target().Version
+	if keyVersion == 0 || keyVersion == pinned {
+		return pinned, nil
+	}
+	// the chain is paired with another key version than the latest one,
+	// typically right after a rotation whose certificate is not issued yet
+	if pinned != 0 {
+		return 0, fmt.Errorf("key version %d is pinned, but the certificate chain is paired with key version %d", pinned, keyVersion)
+	}
+	return keyVersion, nil
+}
+
+// signWithVault signs the payload with the transit key and returns the raw
+// signature along with the certificate chain of the key version that signed.
+// The transit signature and the certificate chain are fetched concurrently,
+// unless the profile checks revocation: a revoked key must never sign, so
+// the chain is then checked before the transit request.
+func signWithVault(ctx context.Context, vaultClient *keyvault.VaultClientWrapper, keySpec signature.KeySpec, payload []byte) ([]byte, []*x509.Certificate, error) {
+	if vaultClient.Profile().RevocationCheck != nil {
+		certs, keyVersion, err := getCertificateChain(ctx, vaultClient)
+		if err != nil {
+			return nil, nil, &proto.RequestError{
+				Code: proto.ErrorCodeGeneric,
+				Err:  fmt.Errorf("failed to get certificate chain, %v", err),
+			}
+		}
+		sigBytes, err := signWithKeyVersion(ctx, vaultClient, keySpec, keyVersion, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sigBytes, certs, nil
+	}
+
+	input, err := newTransitInput(keySpec, payload)
 	if err != nil {
-		return nil, &proto.RequestError{
+		return nil, nil, err
+	}
+
+	// sign and fetch the certificate chain concurrently, the first failure
+	// cancels the other request and is the one reported. A chain that fails
+	// the validity check discards the signature.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var failOnce sync.Once
+	var failure error
+	fail := func(err error) {
+		failOnce.Do(func() {
+			failure = err
+			cancel()
+		})
+	}
+	var sigBytes []byte
+	var signedVersion int
+	signed := make(chan struct{})
+	go func() {
+		defer close(signed)
+		var err error
+		if sigBytes, signedVersion, err = input.sign(ctx, vaultClient, vaultClient.Target().Version); err != nil {
+			fail(err)
+		}
+	}()
+	certs, keyVersion, err := getCertificateChain(ctx, vaultClient)
+	if err != nil {
+		fail(fmt.Errorf("failed to get certificate chain, %v", err))
+	}
+	<-signed
+	if failure == nil && keyVersion != 0 && keyVersion != signedVersion {
+		// the optimistic signature is not by the key version the chain
+		// certifies, sign again with that one
+		var version int
+		if version, failure = signingVersion(vaultClient, keyVersion); failure == nil {
+			sigBytes, _, failure = input.sign(ctx, vaultClient, version)
+		}
+	}
+	if failure != nil {
+		return nil, nil, &proto.RequestError{
 			Code: proto.ErrorCodeGeneric,
-			Err:  fmt.Errorf("failed to encode signing algorithm, %v", err),
+			Err:  failure,
 		}
 	}
+	return sigBytes, certs, nil
+}
 
-	rawCertChain, err := getCertificateChain(ctx, *vaultClient)
+// signWithKeyVersion signs the payload with the transit key version the
+// certificate chain is paired with, keyVersion being 0 if it is not paired.
+func signWithKeyVersion(ctx context.Context, vaultClient *keyvault.VaultClientWrapper, keySpec signature.KeySpec, keyVersion int, payload []byte) ([]byte, error) {
+	input, err := newTransitInput(keySpec, payload)
+	if err != nil {
+		return nil, err
+	}
+	var sigBytes []byte
+	version, err := signingVersion(vaultClient, keyVersion)
+	if err == nil {
+		sigBytes, _, err = input.sign(ctx, vaultClient, version)
+	}
 	if err != nil {
 		return nil, &proto.RequestError{
 			Code: proto.ErrorCodeGeneric,
-			Err:  fmt.Errorf("failed to get certificate chain, %v", err),
+			Err:  err,
 		}
 	}
-
-	return &proto.GenerateSignatureResponse{
-		KeyID:            req.KeyID,
-		Signature:        sigBytes,
-		SigningAlgorithm: string(signatureAlgorithmString),
-		CertificateChain: rawCertChain,
-	}, nil
+	return sigBytes, nil
 }
 
 // computeHash computes the digest of the message with the given hash algorithm.
@@ -115,28 +276,54 @@ func computeHash(hash crypto.Hash, message []byte) ([]byte, error) {
 	return h.Sum(nil), nil
 }
 
-func getAlgorithmFromKeySpec(k proto.KeySpec) string {
+// getAlgorithmFromKeySpec returns the transit signature_algorithm of a key
+// spec, which is empty for ECDSA keys.
+func getAlgorithmFromKeySpec(k proto.KeySpec) (string, bool) {
 	switch k {
-	case proto.KeySpecRSA2048:
-		return "pss"
-	case proto.KeySpecRSA3072:
-		return "pss"
-	case proto.KeySpecRSA4096:
-		return "pss"
+	case proto.KeySpecRSA2048, proto.KeySpecRSA3072, proto.KeySpecRSA4096:
+		return "pss", true
+	case proto.KeySpecEC256, proto.KeySpecEC384, proto.KeySpecEC521:
+		return "", true
 	default:
-		return ""
+		return "", false
 	}
 }
 
-func getCertificateChain(ctx context.Context, vw keyvault.VaultClientWrapper) ([][]byte, error) {
-	certs, err := vw.GetCertificateChain(ctx)
+// ecdsaRawSignature converts an ASN.1 encoded ECDSA signature to the fixed
+// size r || s encoding used by JWS and COSE.
+func ecdsaRawSignature(der []byte, keySize int) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	if rest, err := asn1.Unmarshal(der, &sig); err != nil || len(rest) != 0 {
+		return nil, errors.New("malformed ECDSA signature from Transit secret engine")
+	}
+	size := (keySize + 7) / 8
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 || sig.R.BitLen() > keySize || sig.S.BitLen() > keySize {
+		return nil, errors.New("invalid ECDSA signature from Transit secret engine")
+	}
+	raw := make([]byte, 2*size)
+	sig.R.FillBytes(raw[:size])
+	sig.S.FillBytes(raw[size:])
+	return raw, nil
+}
+
+// getCertificateChain returns the certificate chain of the key and the
+// key version it is paired with. A chain with a certificate that is expired
+// or not yet valid is refused, as verifiers would reject its signatures, and
+// so is a revoked leaf certificate if the profile checks revocation.
+func getCertificateChain(ctx context.Context, vw *keyvault.VaultClientWrapper) ([]*x509.Certificate, int, error) {
+	chain, err := vw.CertificateChain(ctx)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	// build raw cert chain
-	rawCertChain := make([][]byte, 0, len(certs))
-	for _, cert := range certs {
-		rawCertChain = append(rawCertChain, cert.Raw)
+	if err := vaultcrypto.CheckValidityPeriods(chain.Certificates, time.Now()); err != nil {
+		return nil, 0, err
+	}
+	if config := vw.Profile().RevocationCheck; config != nil {
+		if err := checkRevocation(ctx, config, chain.Certificates); err != nil {
+			return nil, 0, err
+		}
 	}
-	return rawCertChain, nil
+	return chain.Certificates, chain.KeyVersion, nil
 }
@@ -0,0 +1,358 @@
+package signature
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"github.com/notaryproject/notation-core-go/testhelper"
+	"github.com/notaryproject/notation-go/plugin/proto"
+	"golang.org/x/crypto/ocsp"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowVault is a Vault stand-in serving transit signatures and a KV v2
+// certificate chain for the key "signing", after an injected latency.
+type slowVault struct {
+	latency     time.Duration
+	signLatency time.Duration
+	chain       []*x509.Certificate
+	kvStatus    int
+	// latestVersion is the latest transit key version, 1 if unset, and
+	// pairedVersion the key version stored with the chain.
+	latestVersion int
+	pairedVersion int
+
+	mu           sync.Mutex
+	signVersions []int
+	chainReads   int
+}
+
+func (v *slowVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// consume the body so that a cancelled request cancels r.Context()
+	var req struct {
+		KeyVersion int `json:"key_version"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	io.Copy(io.Discard, r.Body)
+	var data map[string]any
+	switch r.URL.Path {
+	case "/v1/transit/sign/signing":
+		if !sleep(r, v.latency+v.signLatency) {
+			return
+		}
+		version := req.KeyVersion
+		if version == 0 {
+			version = v.latestVersion
+		}
+		if version == 0 {
+			version = 1
+		}
+		v.mu.Lock()
+		v.signVersions = append(v.signVersions, version)
+		v.mu.Unlock()
+		sig := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{byte(version)}, 256))
+		data = map[string]any{"signature": fmt.Sprintf("vault:v%d:%s", version, sig), "key_version": version}
+	case "/v1/secret/data/signing":
+		if !sleep(r, v.latency) {
+			return
+		}
+		v.mu.Lock()
+		v.chainReads++
+		v.mu.Unlock()
+		if v.kvStatus != 0 {
+			w.WriteHeader(v.kvStatus)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		var chain bytes.Buffer
+		for _, cert := range v.chain {
+			pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		}
+		secret := map[string]any{"certificate": chain.String()}
+		if v.pairedVersion != 0 {
+			secret["key_version"] = v.pairedVersion
+		}
+		data = map[string]any{
+			"data":     secret,
+			"metadata": map[string]any{"version": 1},
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+// sleep waits for d, returning false if the client went away first.
+func sleep(r *http.Request, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// setupSlowVault starts the stand-in and points the plugin config at it.
+func setupSlowVault(tb testing.TB, vault *slowVault) {
+	tb.Helper()
+	vault.chain = []*x509.Certificate{testhelper.GetRSALeafCertificate().Cert, testhelper.GetRSARootCertificate().Cert}
+	server := httptest.NewServer(vault)
+	tb.Cleanup(server.Close)
+
+	configPath := filepath.Join(tb.TempDir(), "config.json")
+	config := fmt.Sprintf(`{"profiles": {"bench": {"address": %q, "auth": {"token": "root"}, "chainCache": {"disabled": true}}}}`, server.URL)
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Setenv(keyvault.EnvConfigPath, configPath)
+}
+
+// setProfileConfig sets a field of the profile written by setupSlowVault.
+func setProfileConfig(t *testing.T, field string, value any) {
+	t.Helper()
+	path := os.Getenv(keyvault.EnvConfigPath)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file map[string]map[string]map[string]any
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	file["profiles"]["bench"][field] = value
+	if data, err = json.Marshal(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func signRequest() *proto.GenerateSignatureRequest {
+	return &proto.GenerateSignatureRequest{
+		KeyID:   "signing",
+		KeySpec: proto.KeySpecRSA3072,
+		Hash:    proto.HashAlgorithmSHA384,
+		Payload: []byte(`{"targetArtifact":{}}`),
+	}
+}
+
+func TestSign(t *testing.T) {
+	vault := &slowVault{}
+	setupSlowVault(t, vault)
+
+	resp, err := Sign(context.Background(), signRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Signature) != 256 || resp.SigningAlgorithm != string(proto.SignatureAlgorithmRSASSA_PSS_SHA384) {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if len(resp.CertificateChain) != 2 || !bytes.Equal(resp.CertificateChain[0], vault.chain[0].Raw) {
+		t.Error("expected the leaf certificate first in the chain")
+	}
+}
+
+func TestSignWithPairedKeyVersion(t *testing.T) {
+	vault := &slowVault{latestVersion: 3, pairedVersion: 2}
+	setupSlowVault(t, vault)
+
+	resp, err := Sign(context.Background(), signRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the optimistic signature with the latest version is discarded
+	if len(vault.signVersions) != 2 || vault.signVersions[1] != 2 || resp.Signature[0] != 2 {
+		t.Errorf("expected a signature by the paired version 2, signed with %v", vault.signVersions)
+	}
+
+	vault.signVersions = nil
+	vault.pairedVersion = 3
+	resp, err = Sign(context.Background(), signRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vault.signVersions) != 1 || resp.Signature[0] != 3 {
+		t.Errorf("expected a single signature by the latest version, signed with %v", vault.signVersions)
+	}
+}
+
+func TestSignChainFailureCancelsSigning(t *testing.T) {
+	setupSlowVault(t, &slowVault{signLatency: 5 * time.Second, kvStatus: http.StatusForbidden})
+
+	start := time.Now()
+	_, err := Sign(context.Background(), signRequest())
+	var reqErr *proto.RequestError
+	if !errors.As(err, &reqErr) || !strings.Contains(reqErr.Err.Error(), "failed to get certificate chain") {
+		t.Fatalf("expected certificate chain error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the pending transit request to be cancelled, Sign took %v", elapsed)
+	}
+}
+
+func TestSignExpiredCertificate(t *testing.T) {
+	vault := &slowVault{}
+	setupSlowVault(t, vault)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Expired"},
+		NotBefore:    time.Now().Add(-48 * time.Hour),
+		NotAfter:     time.Now().Add(-24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vault.chain = []*x509.Certificate{expired}
+
+	_, err = Sign(context.Background(), signRequest())
+	var reqErr *proto.RequestError
+	if !errors.As(err, &reqErr) || !strings.Contains(reqErr.Err.Error(), `certificate "CN=Expired" expired on`) {
+		t.Fatalf("expected an expired certificate error, got %v", err)
+	}
+}
+
+func TestSignTimestampedAlias(t *testing.T) {
+	vault := &slowVault{}
+	setupSlowVault(t, vault)
+	// the default profile does not timestamp, the aliased one does
+	path := os.Getenv(keyvault.EnvConfigPath)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file map[string]any
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	profiles := file["profiles"].(map[string]any)
+	tsa := map[string]any{}
+	for k, v := range profiles["bench"].(map[string]any) {
+		tsa[k] = v
+	}
+	tsa["timestamp"] = map[string]any{"url": "http://127.0.0.1:1/tsr"}
+	profiles["tsa"] = tsa
+	file["defaultProfile"] = "bench"
+	file["aliases"] = map[string]any{"release": map[string]any{"profile": "tsa", "key": "signing"}}
+	if data, err = json.Marshal(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Sign(context.Background(), signRequest()); err != nil {
+		t.Fatalf("unexpected error for the default profile %v", err)
+	}
+	req := signRequest()
+	req.KeyID = "release"
+	_, err = Sign(context.Background(), req)
+	var reqErr *proto.RequestError
+	if !errors.As(err, &reqErr) || reqErr.Code != proto.ErrorCodeValidation || !strings.Contains(reqErr.Err.Error(), `profile "tsa" of key "release" timestamps signatures`) {
+		t.Fatalf("expected a timestamping profile error, got %v", err)
+	}
+	if len(vault.signVersions) != 1 {
+		t.Errorf("expected no transit request for the aliased key, signed %d times", len(vault.signVersions))
+	}
+}
+
+func TestSignRevokedCertificate(t *testing.T) {
+	t.Setenv(keyvault.EnvCacheDir, t.TempDir())
+	vault := &slowVault{}
+	setupSlowVault(t, vault)
+	setProfileConfig(t, "revocationCheck", &keyvault.RevocationConfig{})
+	server := newRevocationServer(t)
+	server.set(ocsp.Revoked, true)
+	vault.chain = []*x509.Certificate{server.leaf, server.ca}
+
+	// the stand-in signatures are opaque, so the request keeps the RSA key
+	// spec of the other tests
+	req := signRequest()
+	_, err := Sign(context.Background(), req)
+	var reqErr *proto.RequestError
+	if !errors.As(err, &reqErr) || !strings.Contains(reqErr.Err.Error(), `certificate "CN=Revocable" was revoked on`) {
+		t.Fatalf("expected a revoked certificate error, got %v", err)
+	}
+	if len(vault.signVersions) != 0 {
+		t.Errorf("expected no transit request for a revoked certificate, signed with %v", vault.signVersions)
+	}
+
+	// a good status signs with the version the chain is paired with
+	t.Setenv(keyvault.EnvCacheDir, t.TempDir())
+	server.set(ocsp.Good, false)
+	vault.latestVersion, vault.pairedVersion = 3, 2
+	resp, err := Sign(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vault.signVersions) != 1 || vault.signVersions[0] != 2 || len(resp.CertificateChain) != 2 {
+		t.Errorf("expected a single signature by the paired version 2, signed with %v", vault.signVersions)
+	}
+}
+
+const benchLatency = 20 * time.Millisecond
+
+// BenchmarkSign measures Sign against a Vault with 20ms round-trips, where
+// the transit signature and the certificate chain are fetched concurrently.
+func BenchmarkSign(b *testing.B) {
+	setupSlowVault(b, &slowVault{latency: benchLatency})
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Sign(ctx, signRequest()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSignSequential is the baseline of BenchmarkSign, issuing the same
+// two Vault requests one after the other.
+func BenchmarkSignSequential(b *testing.B) {
+	setupSlowVault(b, &slowVault{latency: benchLatency})
+	ctx := context.Background()
+	req := signRequest()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vaultClient, err := keyvault.NewVaultClientFromKeyID(ctx, req.KeyID, req.PluginConfig)
+		if err != nil {
+			b.Fatal(err)
+		}
+		hash, _ := computeHash(crypto.SHA384, req.Payload)
+		if _, _, err := vaultClient.SignWithTransit(ctx, base64.StdEncoding.EncodeToString(hash), "pss", "sha2-384"); err != nil {
+			b.Fatal(err)
+		}
+		if _, _, err := getCertificateChain(ctx, vaultClient); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package signature
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	vaultcrypto "github.com/OliverShang/notation-hc-vault/internal/crypto"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+)
+
+// maxTimestampResponseSize bounds TSA responses, which hold a token and the
+// certificates of the TSA.
+const maxTimestampResponseSize = 1 << 20
+
+var (
+	oidTSTInfo = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+
+	timestampHashOIDs = map[crypto.Hash]asn1.ObjectIdentifier{
+		crypto.SHA256: {2, 16, 840, 1, 101, 3, 4, 2, 1},
+		crypto.SHA384: {2, 16, 840, 1, 101, 3, 4, 2, 2},
+		crypto.SHA512: {2, 16, 840, 1, 101, 3, 4, 2, 3},
+	}
+)
+
+// messageImprint is the digest of the timestamped data, RFC 3161 section 2.4.1.
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+// timeStampReq is a request to a TSA, RFC 3161 section 2.4.1.
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+	Extensions     []pkix.Extension      `asn1:"optional,tag:0"`
+}
+
+// pkiStatusInfo is the status of a TSA response, RFC 3161 section 2.4.2.
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional,utf8"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+// timeStampResp is the response of a TSA, RFC 3161 section 2.4.2.
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+// accuracy is the accuracy of the time of a token.
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// tstInfo is the content signed by the TSA, RFC 3161 section 2.4.2.
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time        `asn1:"generalized"`
+	Accuracy       accuracy         `asn1:"optional"`
+	Ordering       bool             `asn1:"optional,default:false"`
+	Nonce          *big.Int         `asn1:"optional"`
+	TSA            asn1.RawValue    `asn1:"optional,tag:0"`
+	Extensions     []pkix.Extension `asn1:"optional,tag:1"`
+}
+
+// requestTimestamp returns an RFC 3161 timestamp token over the signature,
+// issued by the TSA of the config. The response is verified against the
+// request and the token must chain to the configured roots.
+func requestTimestamp(ctx context.Context, config *keyvault.TimestampConfig, hash crypto.Hash, sig []byte) ([]byte, error) {
+	oid, ok := timestampHashOIDs[hash]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %v", hash)
+	}
+	digest, err := computeHash(hash, sig)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	request, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.URL, bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/timestamp-query")
+	client := &http.Client{Timeout: config.RequestTimeout()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s from %s", resp.Status, config.URL)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxTimestampResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxTimestampResponseSize {
+		return nil, fmt.Errorf("response larger than %d bytes", maxTimestampResponseSize)
+	}
+
+	var tsResp timeStampResp
+	if rest, err := asn1.Unmarshal(data, &tsResp); err != nil || len(rest) != 0 {
+		return nil, errors.New("malformed timestamp response")
+	}
+	// granted or grantedWithMods
+	if status := tsResp.Status.Status; status != 0 && status != 1 {
+		return nil, fmt.Errorf("the TSA refused the request with status %d %q", status, tsResp.Status.StatusString)
+	}
+	token := tsResp.TimeStampToken.FullBytes
+	if len(token) == 0 {
+		return nil, errors.New("the response has no timestamp token")
+	}
+
+	roots, err := timestampRoots(config)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := verifyTimestampToken(token, roots, oid, digest, nonce); err != nil {
+		return nil, fmt.Errorf("invalid timestamp token, %v", err)
+	}
+	return token, nil
+}
+
+// verifyTimestampToken verifies that the token is a TSTInfo over digest with
+// the nonce of the request, signed by a time stamping certificate that
+// chains to roots at the time of the token.
+func verifyTimestampToken(token []byte, roots *x509.CertPool, hashOID asn1.ObjectIdentifier, digest []byte, nonce *big.Int) (*tstInfo, error) {
+	contentType, err := encapsulatedContentType(token)
+	if err != nil {
+		return nil, err
+	}
+	if !contentType.Equal(oidTSTInfo) {
+		return nil, fmt.Errorf("unexpected content type %v", contentType)
+	}
+	p7, err := vaultcrypto.ParsePKCS7(token)
+	if err != nil {
+		return nil, err
+	}
+	var info tstInfo
+	if rest, err := asn1.Unmarshal(p7.Content, &info); err != nil || len(rest) != 0 {
+		return nil, errors.New("malformed TSTInfo")
+	}
+	if !info.MessageImprint.HashAlgorithm.Algorithm.Equal(hashOID) || !bytes.Equal(info.MessageImprint.HashedMessage, digest) {
+		return nil, errors.New("the token is not over the signature")
+	}
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("the token does not match the nonce of the request")
+	}
+	if err := p7.VerifyWithChainAtTime(roots, info.GenTime); err != nil {
+		return nil, err
+	}
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		return nil, errors.New("the token must have a single signer")
+	}
+	for _, usage := range signer.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageTimeStamping {
+			return &info, nil
+		}
+	}
+	return nil, fmt.Errorf("certificate %q is not a time stamping certificate", signer.Subject)
+}
+
+// encapsulatedContentType returns the eContentType of a CMS SignedData,
+// which the pkcs7 package does not expose.
+func encapsulatedContentType(token []byte) (asn1.ObjectIdentifier, error) {
+	var contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(token, &contentInfo); err != nil {
+		return nil, errors.New("malformed timestamp token")
+	}
+	var signedData asn1.RawValue
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return nil, errors.New("malformed timestamp token")
+	}
+	// skip the version and the digest algorithms
+	rest := signedData.Bytes
+	for i := 0; i < 2; i++ {
+		var skipped asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &skipped); err != nil {
+			return nil, errors.New("malformed timestamp token")
+		}
+	}
+	var encapContentInfo struct {
+		EContentType asn1.ObjectIdentifier
+		EContent     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(rest, &encapContentInfo); err != nil {
+		return nil, errors.New("malformed timestamp token")
+	}
+	return encapContentInfo.EContentType, nil
+}
+
+// timestampRoots returns the roots the TSA must chain to.
+func timestampRoots(config *keyvault.TimestampConfig) (*x509.CertPool, error) {
+	if config.RootCert == "" {
+		return x509.SystemCertPool()
+	}
+	data, err := os.ReadFile(config.RootCert)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := vaultcrypto.ParseCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s, %v", config.RootCert, err)
+	}
+	roots := x509.NewCertPool()
+	for _, cert := range certs {
+		roots.AddCert(cert)
+	}
+	return roots, nil
+}
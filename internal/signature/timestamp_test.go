@@ -0,0 +1,315 @@
+package signature
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"github.com/OliverShang/notation-hc-vault/internal/keyvault"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	oidSignedData        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidAttrContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSHA256            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidECDSAWithSHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// tsaSignedData and the types below are the CMS SignedData of the tokens,
+// RFC 5652 section 5, with the implicitly tagged fields as raw values.
+type tsaSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo tsaEncapContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []tsaSignerInfo `asn1:"set"`
+}
+
+type tsaEncapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue
+}
+
+type tsaSignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     tsaIssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	SignedAttributes          asn1.RawValue
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type tsaIssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// tsaServer is an RFC 3161 time stamping authority stand-in, signing its
+// tokens with an ECDSA key certified by a test root.
+type tsaServer struct {
+	*httptest.Server
+	root *x509.Certificate
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+
+	mu sync.Mutex
+	// status is the PKIStatus of the responses, 0 (granted) by default.
+	status int
+	// badNonce and badImprint make the tokens mismatch the requests.
+	badNonce   bool
+	badImprint bool
+}
+
+func newTSAServer(t *testing.T, extKeyUsage x509.ExtKeyUsage) *tsaServer {
+	t.Helper()
+	s := &tsaServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "TSA Root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, rootKey.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.root, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	if s.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	der, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test TSA"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{extKeyUsage},
+	}, s.root, s.key.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (s *tsaServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	var req timeStampReq
+	if _, err := asn1.Unmarshal(body, &req); err != nil || r.Header.Get("Content-Type") != "application/timestamp-query" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var resp []byte
+	var err error
+	if s.status > 1 {
+		resp, err = asn1.Marshal(struct{ Status struct{ Status int } }{Status: struct{ Status int }{s.status}})
+	} else {
+		var token []byte
+		if token, err = s.token(req); err == nil {
+			resp, err = asn1.Marshal(struct {
+				Status         struct{ Status int }
+				TimeStampToken asn1.RawValue
+			}{TimeStampToken: asn1.RawValue{FullBytes: token}})
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	w.Write(resp)
+}
+
+// token returns a CMS SignedData over the TSTInfo answering req, with the
+// content type and message digest as signed attributes.
+func (s *tsaServer) token(req timeStampReq) ([]byte, error) {
+	imprint := req.MessageImprint
+	if s.badImprint {
+		imprint.HashedMessage = make([]byte, len(imprint.HashedMessage))
+	}
+	nonce := req.Nonce
+	if s.badNonce {
+		nonce = new(big.Int).Add(nonce, big.NewInt(1))
+	}
+	content, err := asn1.Marshal(tstInfo{
+		Version:        1,
+		Policy:         asn1.ObjectIdentifier{1, 2, 3, 4},
+		MessageImprint: imprint,
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		GenTime:        time.Now().UTC().Truncate(time.Second),
+		Nonce:          nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	type attribute struct {
+		Type  asn1.ObjectIdentifier
+		Value asn1.RawValue `asn1:"set"`
+	}
+	contentDigest := sha256.Sum256(content)
+	contentType, _ := asn1.Marshal(oidTSTInfo)
+	messageDigest, _ := asn1.Marshal(contentDigest[:])
+	// signed attributes are signed as a DER SET, sorted by encoding
+	encoded, err := asn1.Marshal(struct {
+		A []attribute `asn1:"set"`
+	}{A: []attribute{
+		{Type: oidAttrContentType, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: contentType}},
+		{Type: oidAttrMessageDigest, Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: messageDigest}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	var wrapper, attrSet asn1.RawValue
+	asn1.Unmarshal(encoded, &wrapper)
+	asn1.Unmarshal(wrapper.Bytes, &attrSet)
+	attrDigest := sha256.Sum256(wrapper.Bytes)
+	sig, err := s.key.Sign(rand.Reader, attrDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	octets, _ := asn1.Marshal(content)
+	signedData, err := asn1.Marshal(tsaSignedData{
+		Version:          3,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: tsaEncapContentInfo{
+			EContentType: oidTSTInfo,
+			EContent:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: octets},
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: s.cert.Raw},
+		SignerInfos: []tsaSignerInfo{{
+			Version:                   1,
+			IssuerAndSerialNumber:     tsaIssuerAndSerial{asn1.RawValue{FullBytes: s.cert.RawIssuer}, s.cert.SerialNumber},
+			DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			SignedAttributes:          asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrSet.Bytes},
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256},
+			EncryptedDigest:           sig,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{oidSignedData, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData}})
+}
+
+func (s *tsaServer) set(f func(s *tsaServer)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s)
+}
+
+// writeRootCert writes cert as a PEM file and returns its path.
+func writeRootCert(t *testing.T, cert *x509.Certificate) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tsa-root.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func timestampConfig(t *testing.T, config *keyvault.TimestampConfig) *keyvault.TimestampConfig {
+	t.Helper()
+	profile := &keyvault.Profile{Name: "test", Address: "http://127.0.0.1:8200", Timestamp: config}
+	if err := profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestRequestTimestamp(t *testing.T) {
+	ctx := context.Background()
+	server := newTSAServer(t, x509.ExtKeyUsageTimeStamping)
+	config := timestampConfig(t, &keyvault.TimestampConfig{URL: server.URL, RootCert: writeRootCert(t, server.root)})
+	sig := []byte("signature")
+
+	token, err := requestTimestamp(ctx, config, crypto.SHA256, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contentType, err := encapsulatedContentType(token)
+	if err != nil || !contentType.Equal(oidTSTInfo) {
+		t.Errorf("expected a TSTInfo token, got %v %v", contentType, err)
+	}
+	// malformed tokens are errors, not panics of the BER decoder
+	for n := 0; n < len(token); n++ {
+		if _, err := verifyTimestampToken(token[:n], x509.NewCertPool(), nil, nil, nil); err == nil {
+			t.Fatalf("expected a token truncated to %d bytes to be refused", n)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		set    func(s *tsaServer)
+		errMsg string
+	}{
+		{name: "refused", set: func(s *tsaServer) { s.status = 2 }, errMsg: "refused the request with status 2"},
+		{name: "nonce mismatch", set: func(s *tsaServer) { s.badNonce = true }, errMsg: "nonce"},
+		{name: "imprint mismatch", set: func(s *tsaServer) { s.badImprint = true }, errMsg: "not over the signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.set(tt.set)
+			defer server.set(func(s *tsaServer) { s.status, s.badNonce, s.badImprint = 0, false, false })
+			if _, err := requestTimestamp(ctx, config, crypto.SHA256, sig); err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestRequestTimestampUntrusted(t *testing.T) {
+	ctx := context.Background()
+	server := newTSAServer(t, x509.ExtKeyUsageTimeStamping)
+	other := newTSAServer(t, x509.ExtKeyUsageTimeStamping)
+
+	config := timestampConfig(t, &keyvault.TimestampConfig{URL: server.URL, RootCert: writeRootCert(t, other.root)})
+	if _, err := requestTimestamp(ctx, config, crypto.SHA256, []byte("signature")); err == nil || !strings.Contains(err.Error(), "certificate chain") {
+		t.Errorf("expected a TSA of another root to be refused, got %v", err)
+	}
+
+	// a certificate of the right root without the time stamping usage
+	codeSigning := newTSAServer(t, x509.ExtKeyUsageCodeSigning)
+	config = timestampConfig(t, &keyvault.TimestampConfig{URL: codeSigning.URL, RootCert: writeRootCert(t, codeSigning.root)})
+	if _, err := requestTimestamp(ctx, config, crypto.SHA256, []byte("signature")); err == nil || !strings.Contains(err.Error(), "not a time stamping certificate") {
+		t.Errorf("expected a certificate without time stamping usage to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+// Package trust writes notation trust stores and trust policies for the
+// certificates kept in Vault.
+package trust
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/notaryproject/notation-go/verifier/trustpolicy"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// StoreTypeCA is the notation trust store type of certificate authorities.
+const StoreTypeCA = "ca"
+
+var (
+	validStoreName = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+	unsafeFileChar = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+	// certificateFile matches the names returned by CertificateFileName.
+	certificateFile = regexp.MustCompile(`-[0-9a-f]{16}\.crt$`)
+)
+
+// StoreDir returns the directory of the named trust store of the given type
+// in the notation configuration directory configDir.
+func StoreDir(configDir string, storeType string, name string) (string, error) {
+	if !validStoreName.MatchString(name) {
+		return "", fmt.Errorf("invalid trust store name %q, must match [a-zA-Z0-9_.-]+", name)
+	}
+	return filepath.Join(configDir, "truststore", "x509", storeType, name), nil
+}
+
+// Root returns the root certificate of the chain, leaf first, which must
+// be self-signed.
+func Root(certs []*x509.Certificate) (*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates")
+	}
+	root := certs[len(certs)-1]
+	if !bytes.Equal(root.RawIssuer, root.RawSubject) || root.CheckSignature(root.SignatureAlgorithm, root.RawTBSCertificate, root.Signature) != nil {
+		return nil, fmt.Errorf("the chain ends with %q, which is not a self-signed root certificate", root.Subject)
+	}
+	return root, nil
+}
+
+// CertificateFileName returns the trust store file name of the certificate,
+// its common name followed by a prefix of its SHA-256 fingerprint.
+func CertificateFileName(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	name := strings.Trim(unsafeFileChar.ReplaceAllString(cert.Subject.CommonName, "_"), "_.")
+	if name == "" {
+		name = "certificate"
+	}
+	return name + "-" + hex.EncodeToString(sum[:8]) + ".crt"
+}
+
+// WriteCertificates writes each certificate as a PEM file named after
+// CertificateFileName in storeDir, creating it as needed. It returns the
+// files written, skipping the ones already present.
+func WriteCertificates(storeDir string, certs []*x509.Certificate) ([]string, error) {
+	if err := os.MkdirAll(storeDir, 0700); err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, cert := range certs {
+		path := filepath.Join(storeDir, CertificateFileName(cert))
+		if isWritten(path, cert) {
+			continue
+		}
+		if err := os.WriteFile(path, encodePEM(cert), 0600); err != nil {
+			return nil, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+// isWritten reports whether the file at path holds the certificate as
+// written by WriteCertificates.
+func isWritten(path string, cert *x509.Certificate) bool {
+	existing, err := os.ReadFile(path)
+	return err == nil && bytes.Equal(existing, encodePEM(cert))
+}
+
+func encodePEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// SyncResult lists the changes made by Sync, as file names in the trust
+// store.
+type SyncResult struct {
+	Added     []string
+	Removed   []string
+	Unchanged []string
+	// Unmanaged lists the files not written by Sync, which are left alone.
+	Unmanaged []string
+}
+
+// Sync makes the certificates of the trust store at storeDir be certs: the
+// missing ones are written and the ones not in certs are removed. Only the
+// files named by CertificateFileName are managed. With dryRun, the changes
+// are only reported. An empty certs is refused, so that a failure upstream
+// cannot empty the trust store.
+func Sync(storeDir string, certs []*x509.Certificate, dryRun bool) (*SyncResult, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates to synchronize, leaving the trust store alone")
+	}
+	wanted := make(map[string]bool, len(certs))
+	for _, cert := range certs {
+		wanted[CertificateFileName(cert)] = true
+	}
+	entries, err := os.ReadDir(storeDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	result := &SyncResult{}
+	for _, entry := range entries {
+		switch name := entry.Name(); {
+		case !entry.Type().IsRegular() || !certificateFile.MatchString(name):
+			result.Unmanaged = append(result.Unmanaged, name)
+		case !wanted[name]:
+			result.Removed = append(result.Removed, name)
+		}
+	}
+
+	if dryRun {
+		for _, cert := range certs {
+			name := CertificateFileName(cert)
+			if !wanted[name] {
+				continue // duplicate
+			}
+			wanted[name] = false
+			if isWritten(filepath.Join(storeDir, name), cert) {
+				result.Unchanged = append(result.Unchanged, name)
+			} else {
+				result.Added = append(result.Added, name)
+			}
+		}
+		return result, nil
+	}
+	written, err := WriteCertificates(storeDir, certs)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range written {
+		result.Added = append(result.Added, filepath.Base(path))
+		delete(wanted, filepath.Base(path))
+	}
+	for name := range wanted {
+		result.Unchanged = append(result.Unchanged, name)
+	}
+	sort.Strings(result.Unchanged)
+	for _, name := range result.Removed {
+		if err := os.Remove(filepath.Join(storeDir, name)); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Policy returns a trust policy document with a single strict policy
+// trusting the named CA trust store for the registry scopes, and only
+// signatures of the leaf certificate subject. The document is returned along
+// with the reason notation would reject it, if any.
+func Policy(name string, storeName string, registryScopes []string, leaf *x509.Certificate) (*trustpolicy.Document, error) {
+	doc := &trustpolicy.Document{
+		Version: "1.0",
+		TrustPolicies: []trustpolicy.TrustPolicy{{
+			Name:                  name,
+			RegistryScopes:        registryScopes,
+			SignatureVerification: trustpolicy.SignatureVerification{VerificationLevel: trustpolicy.LevelStrict.Name},
+			TrustStores:           []string{StoreTypeCA + ":" + storeName},
+			TrustedIdentities:     []string{"x509.subject: " + leaf.Subject.String()},
+		}},
+	}
+	return doc, doc.Validate()
+}
+
+// MergePolicy adds the trust policies of update to doc, replacing the ones of
+// the same name. It returns the reason notation would reject the merged
+// document, if any, e.g. two policies with the wildcard registry scope.
+func MergePolicy(doc *trustpolicy.Document, update *trustpolicy.Document) error {
+	for _, policy := range update.TrustPolicies {
+		replaced := false
+		for i := range doc.TrustPolicies {
+			if doc.TrustPolicies[i].Name == policy.Name {
+				doc.TrustPolicies[i] = policy
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			doc.TrustPolicies = append(doc.TrustPolicies, policy)
+		}
+	}
+	return doc.Validate()
+}
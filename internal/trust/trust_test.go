@@ -0,0 +1,179 @@
+package trust
+
+import (
+	"context"
+	"crypto/x509"
+	"github.com/notaryproject/notation-core-go/testhelper"
+	"github.com/notaryproject/notation-go/dir"
+	"github.com/notaryproject/notation-go/verifier/truststore"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStoreDir(t *testing.T) {
+	path, err := StoreDir("/etc/notation", StoreTypeCA, "release.prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join("/etc/notation", "truststore", "x509", "ca", "release.prod") {
+		t.Errorf("unexpected trust store directory %s", path)
+	}
+	for _, name := range []string{"", "team/release", "release key"} {
+		if _, err := StoreDir("/etc/notation", StoreTypeCA, name); err == nil {
+			t.Errorf("expected name %q to be refused", name)
+		}
+	}
+}
+
+func TestRoot(t *testing.T) {
+	leaf, root := testhelper.GetRSALeafCertificate().Cert, testhelper.GetRSARootCertificate().Cert
+	if got, err := Root([]*x509.Certificate{leaf, root}); err != nil || got != root {
+		t.Errorf("expected the root certificate, got %v, %v", got, err)
+	}
+	if _, err := Root([]*x509.Certificate{leaf}); err == nil || !strings.Contains(err.Error(), "not a self-signed root") {
+		t.Errorf("expected an error for a chain without root, got %v", err)
+	}
+	if _, err := Root(nil); err == nil {
+		t.Error("expected an error for an empty chain")
+	}
+}
+
+func TestWriteCertificates(t *testing.T) {
+	configDir := t.TempDir()
+	storeDir, err := StoreDir(configDir, StoreTypeCA, "release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := testhelper.GetRSARootCertificate().Cert
+	written, err := WriteCertificates(storeDir, []*x509.Certificate{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 || filepath.Base(written[0]) != CertificateFileName(root) {
+		t.Fatalf("unexpected files written %v", written)
+	}
+	if info, err := os.Stat(written[0]); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected certificate file mode %v, %v", info, err)
+	}
+	if written, err = WriteCertificates(storeDir, []*x509.Certificate{root}); err != nil || len(written) != 0 {
+		t.Errorf("expected an up to date trust store to be left alone, got %v, %v", written, err)
+	}
+
+	// notation reads the trust store back
+	store := truststore.NewX509TrustStore(dir.NewSysFS(configDir))
+	certs, err := store.GetCertificates(context.Background(), truststore.TypeCA, "release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || !certs[0].Equal(root) {
+		t.Errorf("unexpected trust store certificates %v", certs)
+	}
+}
+
+func TestCertificateFileName(t *testing.T) {
+	root := testhelper.GetRSARootCertificate().Cert
+	name := CertificateFileName(root)
+	if !strings.HasSuffix(name, ".crt") || strings.ContainsAny(name, " /") {
+		t.Errorf("unexpected file name %q", name)
+	}
+	if CertificateFileName(testhelper.GetECRootCertificate().Cert) == name {
+		t.Error("expected certificates of the same name to get distinct files")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	leaf := testhelper.GetRSALeafCertificate().Cert
+	doc, err := Policy("release", "release", []string{"registry.example.com/release"}, leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := doc.TrustPolicies[0]
+	if policy.TrustStores[0] != "ca:release" || policy.TrustedIdentities[0] != "x509.subject: "+leaf.Subject.String() || policy.SignatureVerification.VerificationLevel != "strict" {
+		t.Errorf("unexpected trust policy %+v", policy)
+	}
+
+	// notation requires C, ST and O in trusted identities
+	noProvince := *leaf
+	noProvince.Subject.Province = nil
+	if _, err := Policy("release", "release", []string{"*"}, &noProvince); err == nil {
+		t.Error("expected a subject without ST to be reported")
+	}
+}
+
+func TestSync(t *testing.T) {
+	storeDir := filepath.Join(t.TempDir(), "truststore", "x509", "ca", "vault-pki")
+	rsaRoot, ecRoot := testhelper.GetRSARootCertificate().Cert, testhelper.GetECRootCertificate().Cert
+
+	if _, err := Sync(storeDir, nil, false); err == nil {
+		t.Error("expected syncing no certificates to be refused")
+	}
+	result, err := Sync(storeDir, []*x509.Certificate{rsaRoot, ecRoot, rsaRoot}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added) != 2 || len(result.Removed) != 0 || len(result.Unchanged) != 0 {
+		t.Errorf("unexpected first sync %+v", result)
+	}
+	if err := os.WriteFile(filepath.Join(storeDir, "manual.crt"), []byte("kept"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the EC root is retired
+	result, err = Sync(storeDir, []*x509.Certificate{rsaRoot}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added) != 0 || len(result.Removed) != 1 || len(result.Unchanged) != 1 || len(result.Unmanaged) != 1 {
+		t.Errorf("unexpected dry run %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(storeDir, CertificateFileName(ecRoot))); err != nil {
+		t.Error("expected a dry run to leave the trust store alone")
+	}
+	result, err = Sync(storeDir, []*x509.Certificate{rsaRoot}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Removed) != 1 || result.Removed[0] != CertificateFileName(ecRoot) || len(result.Unchanged) != 1 {
+		t.Errorf("unexpected sync %+v", result)
+	}
+	entries, err := os.ReadDir(storeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected the RSA root and the unmanaged file to be left, got %v", entries)
+	}
+}
+
+func TestMergePolicy(t *testing.T) {
+	leaf := testhelper.GetRSALeafCertificate().Cert
+	doc, err := Policy("release", "release", []string{"registry.example.com/release"}, leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update, err := Policy("dev", "dev", []string{"*"}, leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MergePolicy(doc, update); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.TrustPolicies) != 2 || doc.TrustPolicies[1].Name != "dev" {
+		t.Errorf("expected the policy to be added, got %+v", doc.TrustPolicies)
+	}
+	update.TrustPolicies[0].TrustStores = []string{"ca:dev-2"}
+	if err := MergePolicy(doc, update); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.TrustPolicies) != 2 || doc.TrustPolicies[1].TrustStores[0] != "ca:dev-2" {
+		t.Errorf("expected the policy to be replaced, got %+v", doc.TrustPolicies)
+	}
+
+	// a second wildcard scope is rejected by notation
+	conflict, _ := Policy("other", "other", []string{"*"}, leaf)
+	if err := MergePolicy(doc, conflict); err == nil {
+		t.Error("expected two wildcard policies to be reported")
+	}
+}